@@ -2,6 +2,7 @@ package assembler
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -9,6 +10,44 @@ import (
 	"github.com/upryst/slede8dbg/vm"
 )
 
+// LineError is an assembler error tied to a (1-based) source line.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("Line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Line is a single source line along with the result of assembling it.
+type Line struct {
+	Number int
+	Text   string
+
+	Label    string
+	Mnemonic string
+	Args     string
+
+	// Label referenced by FINN / HOPP / BHOPP / TUR, if any
+	LabelRef string
+
+	Addr     uint16
+	Bytecode []byte
+	Err      error
+}
+
+// Listing is the line by line result of assembling a source file.
+// Unlike Assemble, List doesn't stop at the first error.
+type Listing struct {
+	Lines  []*Line
+	Labels map[string]uint16
+}
+
 func AssembleLine(line string) ([]byte, error) {
 	label, mnemonic, args, err := tokenize(line)
 	if err != nil {
@@ -23,52 +62,94 @@ func AssembleLine(line string) ([]byte, error) {
 }
 
 func Assemble(src string) ([]byte, error) {
+	listing := List(src)
+	if err := listing.Err(); err != nil {
+		return nil, err
+	}
+
+	return listing.Bytecode(), nil
+}
+
+func List(src string) *Listing {
+	listing := &Listing{
+		Labels: make(map[string]uint16),
+	}
+
 	// First pass, collect labels
-	labels := make(map[string]uint16)
 	var offset uint16
-	for i, line := range strings.Split(src, "\n") {
-		label, mnemonic, args, err := tokenize(line)
+	for i, text := range strings.Split(src, "\n") {
+		line := &Line{Number: i + 1, Text: text, Addr: offset}
+		listing.Lines = append(listing.Lines, line)
+
+		label, mnemonic, args, err := tokenize(text)
 		if err != nil {
-			return nil, errors.Errorf("Line %d: %v", i+1, err)
+			line.Err = err
+			continue
 		}
+		line.Label, line.Mnemonic, line.Args = label, mnemonic, args
 
 		if label != "" {
-			labels[label] = offset
+			listing.Labels[label] = offset
 			continue
 		}
 
+		switch strings.ToUpper(mnemonic) {
+		case "FINN", "HOPP", "BHOPP", "TUR":
+			if _, ref, err := parseImm12OrLabel(args); err == nil {
+				line.LabelRef = ref
+			}
+		}
+
 		bytecode, err := assemble(multilineFirstPass, nil, mnemonic, args)
 		if err != nil {
-			return nil, errors.Errorf("Line %d: %v", i+1, err)
+			line.Err = err
+			continue
 		}
 
-		offset += uint16(len(bytecode))
-
-		if offset >= vm.MemSize {
-			return nil, errors.Errorf("Program doesn't fit %d bytes", vm.MemSize)
+		if offset < vm.MemSize && offset+uint16(len(bytecode)) >= vm.MemSize {
+			line.Err = errors.Errorf("Program doesn't fit %d bytes", vm.MemSize)
 		}
-	}
 
-	var output bytes.Buffer
+		offset += uint16(len(bytecode))
+	}
 
 	// Second (and final) pass with known label addresses
-	for i, line := range strings.Split(src, "\n") {
-		label, mnemonic, args, err := tokenize(line)
-		if err != nil {
-			return nil, err
-		}
-
-		if label != "" {
+	for _, line := range listing.Lines {
+		if line.Err != nil || line.Label != "" {
 			continue
 		}
 
-		bytecode, err := assemble(multilineFinalPass, labels, mnemonic, args)
-		if err != nil {
-			return nil, errors.Errorf("Line %d: %v", i+1, err)
+		line.Bytecode, line.Err = assemble(multilineFinalPass, listing.Labels,
+			line.Mnemonic, line.Args)
+	}
+
+	return listing
+}
+
+// Err returns the error of the first line that failed to assemble.
+func (l *Listing) Err() error {
+	for _, line := range l.Lines {
+		if line.Err != nil {
+			return &LineError{line.Number, line.Err}
 		}
+	}
+	return nil
+}
 
-		output.Write(bytecode)
+func (l *Listing) Bytecode() []byte {
+	var output bytes.Buffer
+	for _, line := range l.Lines {
+		output.Write(line.Bytecode)
 	}
+	return output.Bytes()
+}
 
-	return output.Bytes(), nil
+// LineAt returns the line which assembled into the byte at addr.
+func (l *Listing) LineAt(addr uint16) *Line {
+	for _, line := range l.Lines {
+		if addr >= line.Addr && addr < line.Addr+uint16(len(line.Bytecode)) {
+			return line
+		}
+	}
+	return nil
 }
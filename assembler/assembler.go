@@ -16,6 +16,16 @@ const (
 	multilineFinalPass
 )
 
+// Mnemonics lists all instructions and directives understood by the assembler.
+var Mnemonics = []string{
+	"SETT", "FINN", "LAST", "LAGR",
+	"OG", "ELLER", "XELLER", "VSKIFT", "HSKIFT", "PLUSS", "MINUS",
+	"LES", "SKRIV",
+	"LIK", "ULIK", "ME", "MEL", "SE", "SEL",
+	"HOPP", "BHOPP", "TUR", "RETUR",
+	"STOPP", "NOPE", ".DATA",
+}
+
 var aluOps = map[string]byte{
 	"OG":     0,
 	"ELLER":  1,
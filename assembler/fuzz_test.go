@@ -0,0 +1,110 @@
+//go:build go1.18
+// +build go1.18
+
+package assembler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+func FuzzAssemble(f *testing.F) {
+	f.Add("    FINN data\n    LAST r2\n    STOPP\ndata:\n    .DATA \"hi\", 0x00\n")
+	f.Add("loop:\n    HOPP loop ; forever\n")
+	f.Add("    SETT r0, 'a'\n    TUR 0xfff\n")
+
+	f.Fuzz(func(t *testing.T, src string) {
+		code, err := Assemble(src)
+		if err != nil {
+			return
+		}
+		if listed := List(src).Bytecode(); !bytes.Equal(code, listed) {
+			t.Errorf("Assemble %x differs from List %x", code, listed)
+		}
+	})
+}
+
+func FuzzParseData(f *testing.F) {
+	f.Add(`"AB", 0, 0x10, 255`)
+	f.Add(`'a', 1fh`)
+	f.Add(`"with \"quotes\""`)
+
+	f.Fuzz(func(t *testing.T, args string) {
+		data, err := parseData(args)
+		if err != nil {
+			return
+		}
+
+		// The bytes written as numbers parse back the same
+		var numbers []string
+		for _, b := range data {
+			numbers = append(numbers, fmt.Sprintf("0x%02x", b))
+		}
+		again, err := parseData(strings.Join(numbers, ", "))
+		if err != nil || !bytes.Equal(data, again) {
+			t.Errorf("%q: %x became %x (%v)", args, data, again, err)
+		}
+	})
+}
+
+func FuzzDisassembly(f *testing.F) {
+	f.Add(uint16(0x0501))
+	f.Add(uint16(0xfff8))
+	f.Add(uint16(0x0075))
+
+	f.Fuzz(func(t *testing.T, word uint16) {
+		checkDisassembly(t, word)
+	})
+}
+
+// TestDisassembly checks every word, see checkDisassembly.
+func TestDisassembly(t *testing.T) {
+	exact := 0
+	for w := 0; w <= 0xffff; w++ {
+		if checkDisassembly(t, uint16(w)) {
+			exact++
+		}
+	}
+	t.Logf("%d words reassemble exactly", exact)
+}
+
+// dontCare are the bits of each instruction class the VM ignores, which
+// the assembler leaves 0: everything above the class for STOPP, RETUR and
+// NOPE, and the last nibble of the register forms of SETT, LAST, LAGR, LES
+// and SKRIV. The other classes use all 16 bits.
+var dontCare = map[vm.OpClass]uint16{
+	vm.OpClassHalt:      0xfff0,
+	vm.OpClassMovReg:    0xf000,
+	vm.OpClassLoadStore: 0xf000,
+	vm.OpClassIO:        0xf000,
+	vm.OpClassRet:       0xfff0,
+	vm.OpClassNop:       0xfff0,
+}
+
+// checkDisassembly reassembles the disassembly of word, which must give
+// the same word but for its don't-care bits, assembled as 0. Unsupported
+// words disassemble to .DATA and must come back exactly.
+func checkDisassembly(t *testing.T, word uint16) (exact bool) {
+	i := vm.ParseInstruction(word)
+	text := i.String()
+	code, err := AssembleLine(text)
+	if err != nil {
+		t.Fatalf("%04x: %q doesn't assemble: %v", word, text, err)
+	} else if len(code) != 2 {
+		t.Fatalf("%04x: %q assembled to %x", word, text, code)
+	}
+
+	mask := dontCare[i.Class]
+	if strings.HasPrefix(text, ".DATA") {
+		mask = 0
+	}
+	again := uint16(code[0]) | uint16(code[1])<<8
+	if again != word&^mask {
+		t.Fatalf("%04x: %q assembled to %04x", word, text, again)
+	}
+	return again == word
+}
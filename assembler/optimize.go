@@ -0,0 +1,269 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// Savings of Optimize, in instructions removed or changed.
+type Savings struct {
+	Nops        int // NOPEs removed
+	Sets        int // SETTs of the value the register already has
+	JumpsToNext int // HOPPs and BHOPPs to the next instruction
+	JumpChains  int // jumps to a HOPP sent to its target instead
+	DeadCode    int // instructions after HOPP, RETUR or STOPP without a label
+
+	Before, After int // bytes
+}
+
+func (s *Savings) String() string {
+	return fmt.Sprintf("%d -> %d bytes: %d NOPE, %d SETT, %d jumps to next, %d jump chains, %d dead code",
+		s.Before, s.After, s.Nops, s.Sets, s.JumpsToNext, s.JumpChains, s.DeadCode)
+}
+
+// optimizer works on the lines of a listing, removed lines are left empty
+// so the line numbers stay the same.
+type optimizer struct {
+	lines   []*Line
+	removed []bool
+	args    []string // new arguments of jumps, "" when unchanged
+	labels  map[string]int
+	savings Savings
+}
+
+// Optimize applies peephole optimizations: NOPEs, SETTs not changing the
+// register, jumps to the next instruction and dead code are removed, and
+// jumps to a HOPP go to its target. Labels and .DATA are kept as they are,
+// but code is taken to be only executed, not read or written as data.
+// Addresses inside the program given by number (not label) would move, so
+// they are an error.
+func (l *Listing) Optimize() (*Listing, *Savings, error) {
+	if err := l.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	o := &optimizer{
+		lines:   l.Lines,
+		removed: make([]bool, len(l.Lines)),
+		args:    make([]string, len(l.Lines)),
+		labels:  map[string]int{},
+	}
+	o.savings.Before = len(l.Bytecode())
+
+	for k, line := range l.Lines {
+		if line.Label != "" {
+			o.labels[line.Label] = k
+		}
+		if i := o.instruction(k); i != nil && line.LabelRef == "" && int(i.Addr) < o.savings.Before {
+			switch i.Class {
+			case vm.OpClassFinn, vm.OpClassJmp, vm.OpClassCondJmp, vm.OpClassCall:
+				return nil, nil, &LineError{line.Number,
+					errors.Errorf("Address 0x%03x given by number would move when optimizing", i.Addr)}
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = o.removeNops()
+		changed = o.shortenChains() || changed
+		changed = o.removeJumpsToNext() || changed
+		changed = o.removeDeadCode() || changed
+		changed = o.removeSets() || changed
+	}
+
+	var src strings.Builder
+	for k, line := range o.lines {
+		switch {
+		case o.removed[k]:
+		case o.args[k] != "":
+			fmt.Fprintf(&src, "    %s %s", line.Mnemonic, o.args[k])
+		default:
+			src.WriteString(line.Text)
+		}
+		src.WriteString("\n")
+	}
+
+	optimized := List(strings.TrimSuffix(src.String(), "\n"))
+	if err := optimized.Err(); err != nil {
+		return nil, nil, err
+	}
+	o.savings.After = len(optimized.Bytecode())
+	return optimized, &o.savings, nil
+}
+
+// instruction is the instruction on line k, nil for other lines.
+func (o *optimizer) instruction(k int) *vm.Instruction {
+	line := o.lines[k]
+	if o.removed[k] || len(line.Bytecode) != 2 || strings.ToUpper(line.Mnemonic) == ".DATA" {
+		return nil
+	}
+	return vm.ParseInstruction(uint16(line.Bytecode[0]) | uint16(line.Bytecode[1])<<8)
+}
+
+// next is the first line from k on with bytes, len(lines) if none.
+func (o *optimizer) next(k int) int {
+	for ; k < len(o.lines); k++ {
+		if !o.removed[k] && len(o.lines[k].Bytecode) > 0 {
+			return k
+		}
+	}
+	return k
+}
+
+// target is the label a jump on line k goes to, "" when given by number.
+func (o *optimizer) target(k int) string {
+	if o.args[k] != "" {
+		return o.args[k]
+	}
+	return o.lines[k].LabelRef
+}
+
+func (o *optimizer) remove(k int, count *int) {
+	o.removed[k] = true
+	*count++
+}
+
+func (o *optimizer) removeNops() bool {
+	changed := false
+	for k := range o.lines {
+		if i := o.instruction(k); i != nil && i.Class == vm.OpClassNop {
+			o.remove(k, &o.savings.Nops)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// shortenChains sends HOPP, BHOPP and TUR to a HOPP to where it goes.
+func (o *optimizer) shortenChains() bool {
+	changed := false
+	for k := range o.lines {
+		i := o.instruction(k)
+		if i == nil || i.Class != vm.OpClassJmp && i.Class != vm.OpClassCondJmp && i.Class != vm.OpClassCall {
+			continue
+		}
+
+		label := o.target(k)
+		seen := map[string]bool{}
+		for label != "" && !seen[label] {
+			seen[label] = true
+			to := o.next(o.labels[label])
+			if to == len(o.lines) || to == k {
+				break
+			}
+			if next := o.instruction(to); next == nil || next.Class != vm.OpClassJmp || o.target(to) == "" || seen[o.target(to)] {
+				break
+			}
+			label = o.target(to)
+		}
+
+		if label != o.target(k) {
+			o.args[k] = label
+			o.savings.JumpChains++
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (o *optimizer) removeJumpsToNext() bool {
+	changed := false
+	for k := range o.lines {
+		i := o.instruction(k)
+		if i == nil || i.Class != vm.OpClassJmp && i.Class != vm.OpClassCondJmp || o.target(k) == "" {
+			continue
+		}
+		if o.next(o.labels[o.target(k)]) == o.next(k+1) {
+			o.remove(k, &o.savings.JumpsToNext)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeDeadCode removes the instructions after a HOPP, RETUR or STOPP up
+// to the next label or .DATA.
+func (o *optimizer) removeDeadCode() bool {
+	changed, dead := false, false
+	for k, line := range o.lines {
+		if line.Label != "" || strings.ToUpper(line.Mnemonic) == ".DATA" {
+			dead = false
+			continue
+		}
+		i := o.instruction(k)
+		if i == nil {
+			continue
+		}
+		if dead {
+			o.remove(k, &o.savings.DeadCode)
+			changed = true
+			continue
+		}
+		switch i.Class {
+		case vm.OpClassJmp, vm.OpClassRet, vm.OpClassHalt:
+			dead = true
+		}
+	}
+	return changed
+}
+
+// removeSets removes SETTs of a value the register is known to have,
+// following register values through straight line code. Labels nothing
+// refers to any more (e.g. after removing a jump to the next instruction)
+// are only reached falling through, so the values are kept there.
+func (o *optimizer) removeSets() bool {
+	referenced := map[string]bool{}
+	for k := range o.lines {
+		if o.instruction(k) != nil && o.target(k) != "" {
+			referenced[o.target(k)] = true
+		}
+	}
+
+	changed := false
+	var known [vm.RegCount]int
+	forget := func() {
+		for r := range known {
+			known[r] = -1
+		}
+	}
+	forget()
+
+	for k, line := range o.lines {
+		if referenced[line.Label] || strings.ToUpper(line.Mnemonic) == ".DATA" {
+			forget()
+			continue
+		}
+		i := o.instruction(k)
+		if i == nil {
+			continue
+		}
+
+		switch i.Class {
+		case vm.OpClassMovImm:
+			if known[i.Op] == int(i.Val) {
+				o.remove(k, &o.savings.Sets)
+				changed = true
+			}
+			known[i.Op] = int(i.Val)
+		case vm.OpClassMovReg:
+			if i.Op == i.Arg1 || known[i.Op] >= 0 && known[i.Op] == known[i.Arg1] {
+				o.remove(k, &o.savings.Sets)
+				changed = true
+			}
+			known[i.Op] = known[i.Arg1]
+		case vm.OpClassFinn:
+			known[0], known[1] = -1, -1
+		case vm.OpClassLoadStore, vm.OpClassALU, vm.OpClassIO:
+			if i.Class == vm.OpClassALU || i.Op == 0 {
+				known[i.Arg1] = -1
+			}
+		case vm.OpClassJmp, vm.OpClassCall, vm.OpClassRet, vm.OpClassHalt:
+			forget()
+		}
+	}
+	return changed
+}
@@ -0,0 +1,168 @@
+package assembler
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// optimizable has one or more of every optimization (the NOPE after
+// RETUR is dead code too), it echoes the input up to the first 5.
+const optimizable = `
+    SETT r2, 5
+    NOPE
+    SETT r2, 5
+    SETT r3, r2
+    SETT r6, 1
+    HOPP first
+first:
+    HOPP second
+    SETT r9, 9
+second:
+    SETT r7, 0
+loop:
+    LES r4
+    SETT r3, r3
+    LIK r4, r2
+    BHOPP done
+    SKRIV r4
+    HOPP loop
+done:
+    PLUSS r7, r6
+    TUR again
+    LIK r7, r2
+    BHOPP end
+    BHOPP loop
+    STOPP
+again:
+    SETT r3, r2
+    RETUR
+    NOPE
+end:
+    STOPP
+table:
+    .DATA 0x0c, 0x00
+`
+
+const optimized = `
+    SETT r2, 5
+    SETT r3, r2
+    SETT r6, 1
+second:
+    SETT r7, 0
+loop:
+    LES r4
+    LIK r4, r2
+    BHOPP done
+    SKRIV r4
+    HOPP loop
+done:
+    PLUSS r7, r6
+    TUR again
+    LIK r7, r2
+    BHOPP end
+    BHOPP loop
+    STOPP
+again:
+    SETT r3, r2
+    RETUR
+end:
+    STOPP
+table:
+    .DATA 0x0c, 0x00
+`
+
+func TestOptimize(t *testing.T) {
+	listing, savings, err := List(optimizable).Optimize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := Assemble(optimized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(listing.Bytecode(), expected) {
+		t.Errorf("Expected %x, got %x", expected, listing.Bytecode())
+	}
+
+	if *savings != (Savings{Nops: 2, Sets: 2, JumpsToNext: 2, JumpChains: 1, DeadCode: 1, Before: 52, After: 38}) {
+		t.Errorf("Unexpected savings %+v", savings)
+	}
+	if listing.Labels["table"] != 36 || listing.LineAt(listing.Labels["loop"]).Number != 14 {
+		t.Errorf("Labels or line numbers are wrong: %v", listing.Labels)
+	}
+}
+
+func TestOptimizeKeepsBehaviour(t *testing.T) {
+	original := List(optimizable)
+	listing, _, err := original.Optimize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range [][]byte{{}, {5}, {1, 2, 5, 3, 5, 5, 5, 5}, {1, 2, 3}} {
+		var runs [2]*vm.VM
+		for k, code := range [][]byte{original.Bytecode(), listing.Bytecode()} {
+			runs[k], _ = vm.NewVM(append([]byte(vm.SledeHeader), code...), input, 1000)
+			runs[k].RunFast()
+		}
+		if runs[0].State != runs[1].State || !bytes.Equal(runs[0].Output, runs[1].Output) {
+			t.Errorf("%x: %s %x became %s %x", input, runs[0].State, runs[0].Output, runs[1].State, runs[1].Output)
+		}
+		if runs[1].CycleCount > runs[0].CycleCount {
+			t.Errorf("%x: %d cycles became %d", input, runs[0].CycleCount, runs[1].CycleCount)
+		}
+	}
+}
+
+func TestOptimizeUnreferencedLabel(t *testing.T) {
+	// Once the HOPP is gone next is only reached falling through, r2 is
+	// still 5 there
+	listing, savings, err := List(`
+    SETT r2, 5
+    HOPP next
+next:
+    SETT r2, 5
+    SKRIV r2
+    HOPP next
+`).Optimize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if savings.Sets != 0 || savings.JumpsToNext != 1 {
+		t.Errorf("Expected the SETT after a referenced label to stay, got %+v", savings)
+	}
+
+	listing, savings, err = List(`
+    SETT r2, 5
+    HOPP next
+next:
+    SETT r2, 5
+    SKRIV r2
+    STOPP
+`).Optimize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := Assemble("    SETT r2, 5\n    SKRIV r2\n    STOPP\n")
+	if !bytes.Equal(listing.Bytecode(), expected) {
+		t.Errorf("Expected %x, got %x", expected, listing.Bytecode())
+	}
+	if savings.JumpsToNext != 1 || savings.Sets != 1 {
+		t.Errorf("Unexpected savings %+v", savings)
+	}
+}
+
+func TestOptimizeNumericAddress(t *testing.T) {
+	// Past the program is fine
+	if _, _, err := List("    FINN 0x800\n    LAST r2\n    NOPE\n").Optimize(); err != nil {
+		t.Error(err)
+	}
+
+	_, _, err := List("    NOPE\n    HOPP 0x004\n    STOPP\n").Optimize()
+	if lineErr, ok := err.(*LineError); !ok || lineErr.Line != 2 {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+// Package batch runs one SLEDE8 program on many inputs in parallel, each
+// worker resetting its own clone of the loaded VM instead of loading the
+// program again.
+package batch
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// Result of running one input.
+type Result struct {
+	Index  int // of the input, counting from 0
+	Input  []byte
+	State  vm.VMState
+	PC     uint16
+	Cycles int
+	Output []byte
+	Error  *vm.VMError // unless stopped
+}
+
+type resultJSON struct {
+	Index  int         `json:"index"`
+	Input  string      `json:"input"`
+	State  string      `json:"state"`
+	PC     uint16      `json:"pc"`
+	Cycles int         `json:"cycles"`
+	Output string      `json:"output"`
+	Error  *vm.VMError `json:"error,omitempty"`
+}
+
+// MarshalJSON writes the input and output as hex, like run --json.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	return json.Marshal(resultJSON{
+		Index:  r.Index,
+		Input:  hex.EncodeToString(r.Input),
+		State:  r.State.String(),
+		PC:     r.PC,
+		Cycles: r.Cycles,
+		Output: hex.EncodeToString(r.Output),
+		Error:  r.Error,
+	})
+}
+
+type job struct {
+	index int
+	input []byte
+}
+
+// Run executes image, a VM as loaded by vm.NewVM with its settings and a
+// cycle limit (programs may not stop), once per input read from inputs,
+// using workers goroutines (NumCPU when 0). emit is called with the
+// results in input order, from a single goroutine. When emit fails, Run
+// stops reading inputs and returns the error. image isn't changed.
+func Run(image *vm.VM, inputs <-chan []byte, workers int, emit func(*Result) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	jobs := make(chan job)
+	stop := make(chan struct{})
+	go func() {
+		defer close(jobs)
+		for index := 0; ; index++ {
+			input, ok := <-inputs
+			if !ok {
+				return
+			}
+			select {
+			case jobs <- job{index, input}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	done := make(chan *Result, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := image.Clone()
+			m.InputSource, m.OutputSink = nil, nil
+			m.InteractiveInput = false
+			for j := range jobs {
+				m.Reset(j.input)
+				done <- run(m, j)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Results come in any order, the ones ahead wait for the others
+	var err error
+	pending := map[int]*Result{}
+	next := 0
+	for result := range done {
+		if err != nil {
+			continue
+		}
+		pending[result.Index] = result
+		for pending[next] != nil && err == nil {
+			err = emit(pending[next])
+			delete(pending, next)
+			next++
+		}
+		if err != nil {
+			close(stop)
+		}
+	}
+	return err
+}
+
+func run(m *vm.VM, j job) *Result {
+	m.RunFast()
+
+	result := &Result{
+		Index:  j.index,
+		Input:  j.input,
+		State:  m.State,
+		PC:     m.PC,
+		Cycles: m.CycleCount,
+		Output: m.Output,
+	}
+	errors.As(m.LastError, &result.Error)
+	return result
+}
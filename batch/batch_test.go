@@ -0,0 +1,144 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// sumProgram prints the sum of two input bytes, and fails when it's 0.
+const sumProgram = `
+    LES r2
+    LES r3
+    PLUSS r2, r3
+    SKRIV r2
+    SETT r4, 0
+    LIK r2, r4
+    BHOPP fail
+    STOPP
+fail:
+    RETUR
+`
+
+func newImage(t *testing.T) *vm.VM {
+	code, err := assembler.Assemble(sumProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+func feed(inputs [][]byte) <-chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		for _, input := range inputs {
+			ch <- input
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func TestRun(t *testing.T) {
+	image := newImage(t)
+
+	var inputs [][]byte
+	for i := 0; i < 500; i++ {
+		inputs = append(inputs, []byte{byte(i), byte(i >> 8)})
+	}
+	inputs = append(inputs, []byte{1})
+
+	var results []*Result
+	err := Run(image, feed(inputs), 4, func(r *Result) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("Expected %d results, got %d", len(inputs), len(results))
+	}
+
+	for i, r := range results[:500] {
+		sum := byte(i) + byte(i>>8)
+		switch {
+		case r.Index != i || string(r.Input) != string(inputs[i]):
+			t.Fatalf("Result %d is for input %d %x", i, r.Index, r.Input)
+		case sum == 0 && (r.State != vm.Error || r.Error.Kind != vm.KindEmptyStack):
+			t.Errorf("%d: expected an empty stack error, got %s %v", i, r.State, r.Error)
+		case sum != 0 && (r.State != vm.Stopped || r.Error != nil || r.Cycles != 7):
+			t.Errorf("%d: expected stopping after 7 cycles, got %s after %d: %v", i, r.State, r.Cycles, r.Error)
+		case len(r.Output) != 1 || r.Output[0] != sum:
+			t.Errorf("%d: expected output %02x, got %x", i, sum, r.Output)
+		}
+	}
+
+	last := results[500]
+	if last.Error == nil || last.Error.Kind != vm.KindNoMoreInput {
+		t.Errorf("Expected no more input, got %v", last.Error)
+	}
+	if image.CycleCount != 0 || image.State != vm.Running {
+		t.Error("The image was changed")
+	}
+
+	data, err := json.Marshal(results[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"index":1,"input":"0100","state":"stopped","pc":14,"cycles":7,"output":"01"}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+}
+
+func TestEmitError(t *testing.T) {
+	image := newImage(t)
+
+	inputs := make(chan []byte)
+	go func() {
+		// Never closed, Run has to stop reading
+		for i := 0; ; i++ {
+			inputs <- []byte{byte(i), 1}
+		}
+	}()
+
+	emitted := 0
+	failure := errors.New("Disk full")
+	err := Run(image, inputs, 3, func(r *Result) error {
+		if emitted++; r.Index == 10 {
+			return failure
+		}
+		return nil
+	})
+	if err != failure || emitted != 11 {
+		t.Errorf("Expected %v after 11 results, got %v after %d", failure, err, emitted)
+	}
+}
+
+func ExampleRun() {
+	code, _ := assembler.Assemble("LES r0\nSKRIV r0\nSKRIV r0\n")
+	image, _ := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, 100)
+
+	inputs := make(chan []byte, 2)
+	inputs <- []byte("a")
+	inputs <- []byte("b")
+	close(inputs)
+
+	Run(image, inputs, 2, func(r *Result) error {
+		fmt.Printf("%d: %s %q\n", r.Index, r.State, r.Output)
+		return nil
+	})
+	// Output:
+	// 0: stopped "aa"
+	// 1: stopped "bb"
+}
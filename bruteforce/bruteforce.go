@@ -0,0 +1,230 @@
+// Package bruteforce reconstructs SLEDE8 input (føde) one byte at a time:
+// every candidate for the next byte is run, in parallel, and the one
+// getting furthest by a side channel (output, branches or cycles) is kept.
+package bruteforce
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/fuzzer"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// Oracle is the side channel telling how far a candidate got.
+type Oracle int
+
+const (
+	// Output counts the output bytes, or with Options.Output the length of
+	// its longest prefix found in the output
+	Output Oracle = iota
+	// Branches counts the BHOPPs executed, e.g. checks passed
+	Branches
+	// Cycles counts the instructions executed
+	Cycles
+)
+
+var oracleNames = [...]string{"output", "branches", "cycles"}
+
+func (o Oracle) String() string {
+	return oracleNames[o]
+}
+
+// OracleByName is the Oracle called name, as printed by String.
+func OracleByName(name string) (Oracle, error) {
+	for i, oracleName := range oracleNames {
+		if oracleName == name {
+			return Oracle(i), nil
+		}
+	}
+	return 0, errors.Errorf("Unknown oracle %s (output, branches or cycles)", name)
+}
+
+// Options of Run. Without Target and Output, the search goes on while
+// some candidate scores better than the others.
+type Options struct {
+	Oracle     Oracle
+	CycleLimit int
+	Prefix     []byte // known start of the input
+	Charset    []byte // candidates, all bytes when empty
+	Length     int    // pad candidates to this length with Pad (0: no padding)
+	Pad        byte
+	MaxLen     int    // give up after this many bytes
+	Target     int    // address to reach, -1 for none
+	Output     []byte // output to produce (anywhere in the output)
+	Workers    int    // parallel VMs, NumCPU when 0
+
+	// Progress is called with every byte chosen
+	Progress func(Step)
+}
+
+// Step is a byte chosen.
+type Step struct {
+	Pos   int
+	Byte  byte
+	Score int
+	Ties  int // other candidates scoring the same
+}
+
+// Result of Run, Found tells whether the goal (if any) was reached.
+type Result struct {
+	Input  []byte
+	Replay []byte // Input as run, padded to Options.Length
+	Found  bool
+	Steps  []Step
+	Runs   int
+}
+
+var (
+	ErrNoSignal = errors.New("No candidate scores better than the others")
+	ErrMaxLen   = errors.New("Maximum input length reached")
+)
+
+// Printable is the printable ASCII characters.
+var Printable = func() []byte {
+	var chars []byte
+	for c := byte(' '); c <= '~'; c++ {
+		chars = append(chars, c)
+	}
+	return chars
+}()
+
+type outcome struct {
+	score   int
+	reached bool
+}
+
+// Run searches input for program (with the .SLEDE8 header). On ErrNoSignal
+// and ErrMaxLen the result has the input reconstructed so far.
+func Run(program []byte, opts Options) (*Result, error) {
+	if _, err := vm.NewVM(program, nil, opts.CycleLimit); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Charset) == 0 {
+		for c := 0; c < 256; c++ {
+			opts.Charset = append(opts.Charset, byte(c))
+		}
+	}
+	if opts.MaxLen <= 0 {
+		opts.MaxLen = 64
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	goal := opts.Target >= 0 || len(opts.Output) > 0
+
+	result := &Result{Input: append([]byte{}, opts.Prefix...)}
+	done := func(err error) (*Result, error) {
+		result.Replay = pad(result.Input, &opts)
+		return result, err
+	}
+	if goal && run(program, result.Input, &opts).reached {
+		result.Found = true
+		return done(nil)
+	}
+
+	for len(result.Input) < opts.MaxLen {
+		outcomes := tryAll(program, result.Input, &opts)
+		result.Runs += len(outcomes)
+
+		best, ties, signal := 0, 0, false
+		for i, o := range outcomes {
+			switch {
+			case o.reached:
+				result.Input = append(result.Input, opts.Charset[i])
+				result.Found = true
+				return done(nil)
+			case o.score > outcomes[best].score:
+				best, ties = i, 0
+			case o.score == outcomes[best].score && i != best:
+				ties++
+			}
+			signal = signal || o.score != outcomes[0].score
+		}
+		if !signal {
+			return done(ErrNoSignal)
+		}
+
+		step := Step{Pos: len(result.Input), Byte: opts.Charset[best], Score: outcomes[best].score, Ties: ties}
+		result.Input = append(result.Input, step.Byte)
+		result.Steps = append(result.Steps, step)
+		if opts.Progress != nil {
+			opts.Progress(step)
+		}
+	}
+	return done(ErrMaxLen)
+}
+
+// tryAll runs input followed by each candidate on opts.Workers VMs.
+func tryAll(program, input []byte, opts *Options) []outcome {
+	outcomes := make([]outcome, len(opts.Charset))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			candidate := make([]byte, len(input)+1)
+			copy(candidate, input)
+			for i := range next {
+				candidate[len(input)] = opts.Charset[i]
+				outcomes[i] = run(program, candidate, opts)
+			}
+		}()
+	}
+
+	for i := range opts.Charset {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return outcomes
+}
+
+// pad is input padded to opts.Length.
+func pad(input []byte, opts *Options) []byte {
+	padded := append([]byte{}, input...)
+	for len(padded) < opts.Length {
+		padded = append(padded, opts.Pad)
+	}
+	return padded
+}
+
+func run(program, input []byte, opts *Options) outcome {
+	m, _ := vm.NewVM(program, pad(input, opts), opts.CycleLimit)
+	var o outcome
+	if opts.Target < 0 && opts.Oracle != Branches {
+		m.RunFast()
+	} else {
+		for m.State == vm.Running {
+			if int(m.PC) == opts.Target {
+				o.reached = true
+				break
+			}
+			if opts.Oracle == Branches && m.GetByte(m.PC)&0xf == byte(vm.OpClassCondJmp) {
+				o.score++
+			}
+			m.Step()
+		}
+	}
+
+	switch opts.Oracle {
+	case Output:
+		if len(opts.Output) > 0 {
+			o.score = fuzzer.MatchedPrefix(m.Output, opts.Output)
+		} else {
+			o.score = len(m.Output)
+		}
+	case Cycles:
+		o.score = m.CycleCount
+	}
+	if len(opts.Output) > 0 && bytes.Contains(m.Output, opts.Output) {
+		o.reached = true
+	}
+	return o
+}
@@ -0,0 +1,178 @@
+package bruteforce
+
+import (
+	"testing"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// checkProgram reads all 5 bytes, then compares them one by one to
+// "s3cr!", stopping at the first mismatch.
+const checkProgram = `
+    SETT r5, 0
+    SETT r6, 1
+    SETT r7, 5
+    FINN buffer
+read:
+    LES r2
+    LAGR r2
+    PLUSS r0, r6
+    PLUSS r5, r6
+    LIK r5, r7
+    BHOPP compare
+    HOPP read
+compare:
+    SETT r5, 0
+check:
+    LIK r5, r7
+    BHOPP ok
+    FINN buffer
+    PLUSS r0, r5
+    LAST r2
+    FINN secret
+    PLUSS r0, r5
+    LAST r3
+    ULIK r2, r3
+    BHOPP fail
+    PLUSS r5, r6
+    HOPP check
+ok:
+    SETT r2, '!'
+    SKRIV r2
+fail:
+    STOPP
+secret:
+    .DATA "s3cr!"
+buffer:
+`
+
+// echoProgram prints every input byte XORed with 0x20.
+const echoProgram = `
+    SETT r3, 0x20
+loop:
+    LES r2
+    XELLER r2, r3
+    SKRIV r2
+    HOPP loop
+`
+
+func assemble(t *testing.T, src string) ([]byte, map[string]uint16) {
+	listing := assembler.List(src)
+	if err := listing.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(vm.SledeHeader), listing.Bytecode()...), listing.Labels
+}
+
+func TestOracles(t *testing.T) {
+	program, labels := assemble(t, checkProgram)
+
+	for _, oracle := range []Oracle{Branches, Cycles} {
+		var steps []Step
+		result, err := Run(program, Options{
+			Oracle:     oracle,
+			CycleLimit: 1000,
+			Charset:    Printable,
+			Length:     5,
+			Target:     int(labels["ok"]),
+			Workers:    3,
+			Progress:   func(step Step) { steps = append(steps, step) },
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", oracle, err)
+		}
+		if !result.Found || string(result.Input) != "s3cr!" {
+			t.Errorf("%s: expected s3cr!, got %q (found %t)", oracle, result.Input, result.Found)
+		}
+		if len(steps) != 4 || steps[0].Byte != 's' || steps[0].Ties != 0 {
+			t.Errorf("%s: unexpected steps %+v", oracle, steps)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	program, _ := assemble(t, echoProgram)
+
+	result, err := Run(program, Options{
+		Oracle:     Output,
+		CycleLimit: 1000,
+		Prefix:     []byte("sl"),
+		Output:     []byte("SLEDE8"),
+		Target:     -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Found || string(result.Input) != "slede\x18" {
+		t.Errorf("Expected slede\\x18, got %q (found %t)", result.Input, result.Found)
+	}
+	if result.Runs != 4*256 {
+		t.Errorf("Expected 4 rounds of 256 runs, got %d runs", result.Runs)
+	}
+}
+
+func TestNoSignal(t *testing.T) {
+	program, _ := assemble(t, checkProgram)
+
+	// Without padding every candidate fails reading the second byte
+	result, err := Run(program, Options{
+		Oracle:     Cycles,
+		CycleLimit: 1000,
+		Charset:    Printable,
+		Target:     -1,
+	})
+	if err != ErrNoSignal {
+		t.Errorf("Expected ErrNoSignal, got %v", err)
+	} else if len(result.Input) != 0 {
+		t.Errorf("Expected no input, got %q", result.Input)
+	}
+
+	// A known prefix gets it further
+	result, err = Run(program, Options{
+		Oracle:     Output,
+		CycleLimit: 1000,
+		Charset:    Printable,
+		Prefix:     []byte("s3cr"),
+		Target:     -1,
+		MaxLen:     5,
+	})
+	if err != ErrMaxLen || string(result.Input) != "s3cr!" {
+		t.Errorf("Expected s3cr! and ErrMaxLen, got %q, %v", result.Input, err)
+	}
+}
+
+func TestReplayPadded(t *testing.T) {
+	program, labels := assemble(t, checkProgram)
+
+	result, err := Run(program, Options{
+		Oracle:     Cycles,
+		CycleLimit: 1000,
+		Charset:    Printable,
+		Length:     5,
+		Pad:        'x',
+		MaxLen:     2,
+		Target:     int(labels["ok"]),
+	})
+	if err != ErrMaxLen || string(result.Input) != "s3" || string(result.Replay) != "s3xxx" {
+		t.Fatalf("Expected s3, s3xxx and ErrMaxLen, got %q, %q, %v", result.Input, result.Replay, err)
+	}
+
+	// Replaying gets as far as the last step
+	m, _ := vm.NewVM(program, result.Replay, 1000)
+	m.Run()
+	if last := result.Steps[len(result.Steps)-1]; m.CycleCount != last.Score {
+		t.Errorf("Expected %d cycles, got %d", last.Score, m.CycleCount)
+	}
+}
+
+func TestOracleByName(t *testing.T) {
+	for _, oracle := range []Oracle{Output, Branches, Cycles} {
+		if byName, err := OracleByName(oracle.String()); err != nil || byName != oracle {
+			t.Errorf("%s: got %s, %v", oracle, byName, err)
+		}
+	}
+	if _, err := OracleByName("timing"); err == nil {
+		t.Error("Expected an error for timing")
+	}
+}
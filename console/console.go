@@ -0,0 +1,781 @@
+package console
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// Target is the program being debugged, either the interactive UI or
+// a headless session.
+type Target interface {
+	VM() *vm.VM
+	Step() error
+	Run() error
+	Restart() error
+}
+
+// Console executes gdb-like debugger commands, e.g. "break loop",
+// "x/16b 0x100" or "print r0+r1*256".
+type Console struct {
+	target Target
+	labels map[string]uint16
+	out    io.Writer
+}
+
+type command struct {
+	name    string
+	aliases []string
+	usage   string
+	run     func(c *Console, args string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"break", []string{"b"}, "break <location>        set a breakpoint", (*Console).cmdBreak},
+		{"clear", nil, "clear <location>        remove a breakpoint", (*Console).cmdClear},
+		{"watch", []string{"w"}, "watch <address>         break when the byte changes", (*Console).cmdWatch},
+		{"unwatch", nil, "unwatch <address>       remove a watchpoint", (*Console).cmdUnwatch},
+		{"delete", nil, "delete                  remove all break/watchpoints", (*Console).cmdDelete},
+		{"run", []string{"r", "continue", "c"}, "run                     run until a break/watchpoint", (*Console).cmdRun},
+		{"step", []string{"s", "si"}, "step [n]                execute n instructions", (*Console).cmdStep},
+		{"until", []string{"u"}, "until <location>        run until PC reaches location", (*Console).cmdUntil},
+		{"restart", nil, "restart                 restart from scratch", (*Console).cmdRestart},
+		{"savestate", nil, "savestate <file>        save a VM snapshot (debug --load-state)", (*Console).cmdSaveState},
+		{"print", []string{"p"}, "print <expr>            evaluate e.g. r0+r1*256", (*Console).cmdPrint},
+		{"assert", nil, "assert <expr>           fail unless expr is non-zero, e.g. r0 == 'A'", (*Console).cmdAssert},
+		{"echo", nil, "echo <text>             print text", (*Console).cmdEcho},
+		{"input", nil, "input <bytes>           append input, hex or .DATA syntax, e.g. \"abc\", 10", (*Console).cmdInput},
+		{"x", nil, "x/<n><b|c|s|i> <expr>   examine memory", (*Console).cmdExamine},
+		{"set", nil, "set <lvalue>=<expr>     set rN, pc, flag or mem[addr]", (*Console).cmdSet},
+		{"info", []string{"i"}, "info <what>             registers, stack, breakpoints, watchpoints, labels,\n" +
+			"                        input, output, modified (code) or taint", (*Console).cmdInfo},
+		{"check", nil, "check [off|warn|break]  memory checks: uninitialized reads, running past\n" +
+			"                        the program, PC wraparound", (*Console).cmdCheck},
+		{"selfmod", nil, "selfmod [on|off]        break on self-modifying code", (*Console).cmdSelfMod},
+		{"taint", nil, "taint [on|off]          track which input bytes values depend on (info taint)", (*Console).cmdTaint},
+		{"help", []string{"h", "?"}, "help                    this text", (*Console).cmdHelp},
+	}
+}
+
+const (
+	// Addresses further away from the closest label are not symbolized
+	maxSymbolOffset = 0x100
+)
+
+var (
+	examineRe = regexp.MustCompile(`^x(?:/([0-9]*)([bcsi]?))?(?:\s+(.*))?$`)
+	setRe     = regexp.MustCompile(`^(.+?)\s*=\s*(.+)$`)
+)
+
+func NewConsole(target Target, labels map[string]uint16, out io.Writer) *Console {
+	if labels == nil {
+		labels = make(map[string]uint16)
+	}
+	return &Console{
+		target: target,
+		labels: labels,
+		out:    out,
+	}
+}
+
+func (c *Console) vm() *vm.VM {
+	return c.target.VM()
+}
+
+func (c *Console) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.out, format, args...)
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+		for _, alias := range cmd.aliases {
+			if alias == name {
+				return cmd
+			}
+		}
+	}
+	return nil
+}
+
+// Exec executes a single command line, empty lines and # comments are
+// ignored.
+func (c *Console) Exec(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+
+	// "x/16b" has its arguments glued to the command
+	if examineRe.MatchString(line) {
+		return c.cmdExamine(line)
+	}
+
+	fields := strings.SplitN(line, " ", 2)
+	cmd := findCommand(strings.ToLower(fields[0]))
+	if cmd == nil {
+		return errors.Errorf("Unknown command: %s (try help)", fields[0])
+	}
+
+	var args string
+	if len(fields) > 1 {
+		args = strings.TrimSpace(fields[1])
+	}
+	return cmd.run(c, args)
+}
+
+// ExecScript executes commands line by line, stopping at the first error.
+func (c *Console) ExecScript(script io.Reader) error {
+	scanner := bufio.NewScanner(script)
+	for line := 1; scanner.Scan(); line++ {
+		if err := c.Exec(scanner.Text()); err != nil {
+			return errors.Errorf("Line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Symbolize formats addr as "0x012 <label+2>".
+func (c *Console) Symbolize(addr uint16) string {
+	best, bestAddr := "", uint16(0)
+	for label, labelAddr := range c.labels {
+		if labelAddr <= addr && (best == "" || labelAddr > bestAddr ||
+			(labelAddr == bestAddr && label < best)) {
+			best, bestAddr = label, labelAddr
+		}
+	}
+
+	if best == "" || addr-bestAddr >= maxSymbolOffset {
+		return fmt.Sprintf("0x%03x", addr)
+	} else if addr == bestAddr {
+		return fmt.Sprintf("0x%03x <%s>", addr, best)
+	}
+	return fmt.Sprintf("0x%03x <%s+%d>", addr, best, addr-bestAddr)
+}
+
+func (c *Console) eval(expr string) (int, error) {
+	return Eval(c.vm(), c.labels, expr)
+}
+
+func (c *Console) address(expr string) (uint16, error) {
+	if expr == "" {
+		return 0, errors.New("Address expected")
+	}
+	addr, err := c.eval(expr)
+	if err != nil {
+		return 0, err
+	} else if addr < 0 || addr >= vm.MemSize {
+		return 0, errors.Errorf("Address out of range: 0x%x", addr)
+	}
+	return uint16(addr), nil
+}
+
+func (c *Console) printLocation() {
+	m := c.vm()
+
+	if addr, found := m.WatchTriggered(); found {
+		c.printf("Watchpoint %s changed to 0x%02x\n", c.Symbolize(addr), m.GetByte(addr))
+	} else if m.WaitingForInput() {
+		c.printf("Waiting for input (use the input command)\n")
+	}
+
+	if w, found := m.Warning(); found {
+		c.printf("Warning: %s\n", c.describeWarning(w))
+	}
+
+	if sm, found := m.SelfModified(); found && !sm.Executing {
+		c.printf("Self-modifying code: %s modified executed code at %s\n",
+			c.Symbolize(sm.PC), c.Symbolize(sm.Addr))
+	} else if m.BreakOnSelfModify && m.ModifiedCodeNext() {
+		c.printf("Next instruction was modified at runtime\n")
+	}
+
+	switch m.State {
+	case vm.Stopped:
+		c.printf("Stopped at %s after %d cycles\n", c.Symbolize(m.PC), m.CycleCount)
+	case vm.Error:
+		var vmErr *vm.VMError
+		if errors.As(m.LastError, &vmErr) {
+			c.printf("Error at %s: %v (%s, cycle %d, %s)\n", c.Symbolize(vmErr.PC),
+				vmErr.Err, vmErr.Kind, vmErr.Cycle, vmErr.Describe())
+		} else {
+			c.printf("Error at %s: %v\n", c.Symbolize(m.PC), m.LastError)
+		}
+	default:
+		instr := vm.ParseInstruction(m.GetWord(m.PC))
+		c.printf("%s: %s\n", c.Symbolize(m.PC), instr)
+	}
+}
+
+func (c *Console) cmdBreak(args string) error {
+	addr, err := c.address(args)
+	if err != nil {
+		return err
+	}
+	if !c.vm().BreakpointSet(addr) {
+		c.vm().ToggleBreakpoint(addr)
+	}
+	c.printf("Breakpoint at %s\n", c.Symbolize(addr&^1))
+	return nil
+}
+
+func (c *Console) cmdClear(args string) error {
+	addr, err := c.address(args)
+	if err != nil {
+		return err
+	}
+	if !c.vm().BreakpointSet(addr) {
+		return errors.Errorf("No breakpoint at %s", c.Symbolize(addr))
+	}
+	c.vm().ToggleBreakpoint(addr)
+	return nil
+}
+
+func (c *Console) cmdWatch(args string) error {
+	addr, err := c.address(args)
+	if err != nil {
+		return err
+	}
+	c.vm().WatchPoints[addr] = true
+	c.printf("Watchpoint at %s\n", c.Symbolize(addr))
+	return nil
+}
+
+func (c *Console) cmdUnwatch(args string) error {
+	addr, err := c.address(args)
+	if err != nil {
+		return err
+	}
+	if !c.vm().WatchPoints[addr] {
+		return errors.Errorf("No watchpoint at %s", c.Symbolize(addr))
+	}
+	c.vm().WatchPoints[addr] = false
+	return nil
+}
+
+func (c *Console) cmdDelete(args string) error {
+	m := c.vm()
+	m.BreakPoints = [vm.MemSize / 2]bool{}
+	m.WatchPoints = [vm.MemSize]bool{}
+	c.printf("Deleted all breakpoints and watchpoints\n")
+	return nil
+}
+
+// VM errors don't fail run, step and until, printLocation reports them so
+// scripts can check error.
+func (c *Console) cmdRun(args string) error {
+	if err := c.target.Run(); err != nil && vm.ErrorKindOf(err) == 0 {
+		return err
+	}
+	c.printLocation()
+	return nil
+}
+
+func (c *Console) cmdStep(args string) error {
+	count := 1
+	if args != "" {
+		n, err := c.eval(args)
+		if err != nil {
+			return err
+		}
+		count = n
+	}
+
+	for i := 0; i < count && c.vm().State == vm.Running; i++ {
+		if err := c.target.Step(); err != nil && vm.ErrorKindOf(err) == 0 {
+			return err
+		}
+		if _, found := c.vm().WatchTriggered(); found {
+			break
+		}
+	}
+	c.printLocation()
+	return nil
+}
+
+func (c *Console) cmdUntil(args string) error {
+	addr, err := c.address(args)
+	if err != nil {
+		return err
+	}
+
+	m := c.vm()
+	temporary := !m.BreakpointSet(addr)
+	if temporary {
+		m.ToggleBreakpoint(addr)
+	}
+
+	err = c.target.Run()
+
+	if temporary {
+		m.ToggleBreakpoint(addr)
+	}
+	if err != nil && vm.ErrorKindOf(err) == 0 {
+		return err
+	}
+
+	c.printLocation()
+	return nil
+}
+
+func (c *Console) cmdRestart(args string) error {
+	if err := c.target.Restart(); err != nil {
+		return err
+	}
+	c.printLocation()
+	return nil
+}
+
+func (c *Console) cmdSaveState(args string) error {
+	if args == "" {
+		return errors.New("Usage: savestate <file>")
+	}
+
+	f, err := os.Create(args)
+	if err != nil {
+		return err
+	}
+
+	if err := c.vm().SaveState(f); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+
+	c.printf("Saved state to %s\n", args)
+	return nil
+}
+
+func (c *Console) cmdPrint(args string) error {
+	value, err := c.eval(args)
+	if err != nil {
+		return err
+	}
+	c.printf("= 0x%x (%d)\n", value, value)
+	return nil
+}
+
+func (c *Console) cmdAssert(args string) error {
+	value, err := c.eval(args)
+	if err != nil {
+		return err
+	} else if value == 0 {
+		return errors.Errorf("Assertion failed: %s", args)
+	}
+	return nil
+}
+
+func (c *Console) cmdEcho(args string) error {
+	c.printf("%s\n", args)
+	return nil
+}
+
+func (c *Console) cmdInput(args string) error {
+	data, err := ParseBytes(args)
+	if err != nil {
+		return err
+	} else if len(data) == 0 {
+		return errors.New("Usage: input <bytes>")
+	}
+
+	m := c.vm()
+	m.Input = append(m.Input, data...)
+	c.printf("%d bytes added, %d unread\n", len(data), len(m.Input)-m.InputIndex)
+	return nil
+}
+
+func (c *Console) cmdExamine(line string) error {
+	match := examineRe.FindStringSubmatch(line)
+	if match == nil {
+		return errors.New("Usage: x/<n><b|c|s|i> <expr>")
+	}
+
+	count := 1
+	if match[1] != "" {
+		count, _ = strconv.Atoi(match[1])
+	}
+
+	addrExpr := match[3]
+	if addrExpr == "" {
+		addrExpr = "pc"
+	}
+	addr, err := c.address(addrExpr)
+	if err != nil {
+		return err
+	}
+
+	m := c.vm()
+	switch match[2] {
+	case "i":
+		for i := 0; i < count; i++ {
+			instr := vm.ParseInstruction(m.GetWord(addr))
+			c.printf("%s: %02x%02x  %s\n", c.Symbolize(addr), instr.Raw&0xff, instr.Raw>>8, instr)
+			addr = (addr + 2) % vm.MemSize
+		}
+
+	case "s":
+		for i := 0; i < count; i++ {
+			var text strings.Builder
+			start := addr
+			for b := m.GetByte(addr); b != 0 && text.Len() < vm.MemSize; b = m.GetByte(addr) {
+				text.WriteByte(b)
+				addr = (addr + 1) % vm.MemSize
+			}
+			addr = (addr + 1) % vm.MemSize
+			c.printf("%s: %q\n", c.Symbolize(start), text.String())
+		}
+
+	default:
+		const perLine = 8
+		for i := 0; i < count; i += perLine {
+			c.printf("%s:", c.Symbolize(addr))
+			for j := i; j < count && j < i+perLine; j++ {
+				b := m.GetByte(addr)
+				if match[2] == "c" {
+					c.printf(" %q", rune(b))
+				} else {
+					c.printf(" %02x", b)
+				}
+				addr = (addr + 1) % vm.MemSize
+			}
+			c.printf("\n")
+		}
+	}
+
+	return nil
+}
+
+func (c *Console) cmdSet(args string) error {
+	match := setRe.FindStringSubmatch(args)
+	if match == nil {
+		return errors.New("Usage: set <rN|pc|flag|mem[addr]>=<expr>")
+	}
+
+	value, err := c.eval(match[2])
+	if err != nil {
+		return err
+	}
+
+	m := c.vm()
+	lvalue := strings.ToLower(strings.TrimSpace(match[1]))
+	switch {
+	case regRe.MatchString(lvalue):
+		reg, _ := strconv.Atoi(lvalue[1:])
+		m.SetReg(reg, byte(value))
+
+	case lvalue == "pc":
+		if value < 0 || value >= vm.MemSize {
+			return errors.Errorf("Address out of range: 0x%x", value)
+		}
+		m.PC = uint16(value)
+
+	case lvalue == "flag":
+		m.Flag = value != 0
+
+	case strings.HasPrefix(lvalue, "mem[") && strings.HasSuffix(lvalue, "]"):
+		addr, err := c.address(match[1][4 : len(match[1])-1])
+		if err != nil {
+			return err
+		}
+		m.SetByte(addr, byte(value))
+
+	case strings.HasPrefix(lvalue, "*"):
+		addr, err := c.address(match[1][1:])
+		if err != nil {
+			return err
+		}
+		m.SetByte(addr, byte(value))
+
+	default:
+		return errors.Errorf("Can't assign to %s", match[1])
+	}
+
+	return nil
+}
+
+func (c *Console) cmdInfo(args string) error {
+	m := c.vm()
+
+	switch strings.ToLower(args) {
+	case "registers", "reg", "r":
+		for i := 0; i < vm.RegCount; i += 4 {
+			c.printf("r%-2d %02x  r%-2d %02x  r%-2d %02x  r%-2d %02x\n",
+				i, m.Regs[i], i+1, m.Regs[i+1], i+2, m.Regs[i+2], i+3, m.Regs[i+3])
+		}
+		c.printf("pc  %s  flag %v\n", c.Symbolize(m.PC), m.Flag)
+
+	case "stack", "s":
+		if len(m.Stack) == 0 {
+			c.printf("Stack is empty\n")
+		}
+		for i := len(m.Stack) - 1; i >= 0; i-- {
+			c.printf("#%d %s\n", len(m.Stack)-1-i, c.Symbolize(m.Stack[i]))
+		}
+
+	case "breakpoints", "break", "b":
+		found := false
+		for i, set := range m.BreakPoints {
+			if set {
+				c.printf("Breakpoint at %s\n", c.Symbolize(uint16(i*2)))
+				found = true
+			}
+		}
+		if !found {
+			c.printf("No breakpoints\n")
+		}
+
+	case "watchpoints", "watch", "w":
+		found := false
+		for i, set := range m.WatchPoints {
+			if set {
+				c.printf("Watchpoint at %s = 0x%02x\n", c.Symbolize(uint16(i)), m.Mem[i])
+				found = true
+			}
+		}
+		if !found {
+			c.printf("No watchpoints\n")
+		}
+
+	case "labels", "l":
+		labels := make([]string, 0, len(c.labels))
+		for label := range c.labels {
+			labels = append(labels, label)
+		}
+		sort.Slice(labels, func(i, j int) bool {
+			return c.labels[labels[i]] < c.labels[labels[j]]
+		})
+		for _, label := range labels {
+			c.printf("0x%03x %s\n", c.labels[label], label)
+		}
+
+	case "modified", "m":
+		found := false
+		for addr := uint16(0); addr < vm.MemSize; addr += 2 {
+			if !m.Modified(addr) && !m.Modified(addr+1) {
+				continue
+			}
+			found = true
+
+			executed := ""
+			if m.Executed(addr) {
+				executed = ", executed"
+			}
+			c.printf("%s: %s (was %s%s)\n", c.Symbolize(addr), vm.ParseInstruction(m.GetWord(addr)),
+				vm.ParseInstruction(m.OriginalWord(addr)), executed)
+		}
+		if !found {
+			c.printf("No modified memory\n")
+		}
+
+	case "input":
+		c.printf("%x (%d/%d consumed)\n", m.Input, m.InputIndex, len(m.Input))
+
+	case "output", "o":
+		if len(m.Output) == 0 {
+			c.printf("No output\n")
+		} else {
+			c.printf("%x %q\n", m.Output, m.Output)
+		}
+
+	case "taint", "t":
+		c.infoTaint()
+
+	default:
+		return errors.New("Usage: info <registers|stack|breakpoints|watchpoints|labels|input|output|modified|taint>")
+	}
+
+	return nil
+}
+
+func (c *Console) describeWarning(w vm.Warning) string {
+	switch w.Kind {
+	case vm.WarnUninitializedRead:
+		return fmt.Sprintf("%s read uninitialized memory at %s", c.Symbolize(w.PC), c.Symbolize(w.Addr))
+	case vm.WarnPastProgramEnd:
+		return fmt.Sprintf("Executing past the end of the program at %s", c.Symbolize(w.PC))
+	}
+	return w.String()
+}
+
+func (c *Console) cmdCheck(args string) error {
+	m := c.vm()
+	switch args {
+	case "off":
+		m.CheckMemory, m.BreakOnWarning = false, false
+	case "warn", "on":
+		m.CheckMemory, m.BreakOnWarning = true, false
+	case "break":
+		m.CheckMemory, m.BreakOnWarning = true, true
+	case "":
+	default:
+		return errors.New("Usage: check [off|warn|break]")
+	}
+
+	switch {
+	case m.BreakOnWarning:
+		c.printf("Memory checks on, breaking on warnings\n")
+	case m.CheckMemory:
+		c.printf("Memory checks on\n")
+	default:
+		c.printf("Memory checks off\n")
+	}
+	return nil
+}
+
+func (c *Console) cmdSelfMod(args string) error {
+	m := c.vm()
+	switch args {
+	case "on":
+		m.BreakOnSelfModify = true
+	case "off":
+		m.BreakOnSelfModify = false
+	case "":
+	default:
+		return errors.New("Usage: selfmod [on|off]")
+	}
+
+	if m.BreakOnSelfModify {
+		c.printf("Breaking on self-modifying code\n")
+	} else {
+		c.printf("Not breaking on self-modifying code\n")
+	}
+	return nil
+}
+
+func (c *Console) cmdTaint(args string) error {
+	m := c.vm()
+	switch args {
+	case "on":
+		m.TrackTaint = true
+	case "off":
+		m.TrackTaint = false
+	case "":
+	default:
+		return errors.New("Usage: taint [on|off]")
+	}
+
+	switch {
+	case m.TrackTaint && m.InputIndex > 0:
+		c.printf("Tracking taint (restart to include the input already read)\n")
+	case m.TrackTaint:
+		c.printf("Tracking taint\n")
+	default:
+		c.printf("Not tracking taint\n")
+	}
+	return nil
+}
+
+// infoTaint lists the tainted registers, memory (in runs of bytes with
+// the same taint), branches and output.
+func (c *Console) infoTaint() {
+	m := c.vm()
+	if !m.TrackTaint {
+		c.printf("Not tracking taint (taint on)\n")
+		return
+	}
+
+	found := false
+	for i := 0; i < vm.RegCount; i++ {
+		if taint := m.RegTaint(i); len(taint) > 0 {
+			c.printf("r%-2d  %s\n", i, taint)
+			found = true
+		}
+	}
+	if taint := m.FlagTaint(); len(taint) > 0 {
+		c.printf("flag %s\n", taint)
+		found = true
+	}
+
+	for addr := 0; addr < vm.MemSize; {
+		taint := m.MemTaint(uint16(addr))
+		end := addr + 1
+		for end < vm.MemSize && m.MemTaint(uint16(end)).Equal(taint) {
+			end++
+		}
+		if len(taint) > 0 {
+			c.printf("%s (%d bytes): %s\n", c.Symbolize(uint16(addr)), end-addr, taint)
+			found = true
+		}
+		addr = end
+	}
+
+	for addr := uint16(0); addr < vm.MemSize; addr++ {
+		if taint := m.BranchTaint(addr); len(taint) > 0 {
+			c.printf("Branch at %s: %s\n", c.Symbolize(addr), taint)
+			found = true
+		}
+	}
+
+	for i := range m.Output {
+		if taint := m.OutputTaint(i); len(taint) > 0 {
+			c.printf("Output byte %d (0x%02x): %s\n", i, m.Output[i], taint)
+			found = true
+		}
+	}
+
+	if !found {
+		c.printf("Nothing depends on the input\n")
+	}
+}
+
+func (c *Console) cmdHelp(args string) error {
+	for _, cmd := range commands {
+		c.printf("%s\n", cmd.usage)
+	}
+	return nil
+}
+
+// Complete returns candidates for the prefix at the end of line: command
+// names for the first word, labels and registers otherwise.
+func (c *Console) Complete(line string) (prefix string, candidates []string) {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && !strings.HasSuffix(line, " ") {
+		prefix = fields[len(fields)-1]
+	}
+
+	var words []string
+	if len(fields) == 0 || (len(fields) == 1 && prefix != "") {
+		for _, cmd := range commands {
+			words = append(words, cmd.name)
+		}
+	} else {
+		// Complete only the trailing identifier of e.g. "r0+lo"
+		if i := strings.LastIndexAny(prefix, "+-*/%&|^~()[]=<>"); i >= 0 {
+			prefix = prefix[i+1:]
+		}
+		for label := range c.labels {
+			words = append(words, label)
+		}
+		for i := 0; i < vm.RegCount; i++ {
+			words = append(words, fmt.Sprintf("r%d", i))
+		}
+		words = append(words, "pc", "flag", "mem")
+		if fields[0] == "info" || fields[0] == "i" {
+			words = []string{"registers", "stack", "breakpoints", "watchpoints", "labels",
+				"input", "output", "modified", "taint"}
+		}
+	}
+
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			candidates = append(candidates, word)
+		}
+	}
+	sort.Strings(candidates)
+	return prefix, candidates
+}
@@ -0,0 +1,443 @@
+package console
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+const testProgram = `
+    FINN data
+    SETT r2, 1
+loop:
+    LAST r3
+    LIK r3, r4
+    BHOPP done
+    TUR store
+    PLUSS r0, r2
+    HOPP loop
+done:
+    STOPP
+store:
+    SETT r5, r0
+    SETT r0, r6
+    SETT r1, 2
+    LAGR r3
+    PLUSS r6, r2
+    SETT r0, r5
+    SETT r1, 0
+    RETUR
+data:
+    .DATA "AB", 0
+`
+
+func newTestConsole(t *testing.T) (*Console, *Session, *bytes.Buffer) {
+	return newConsoleFor(t, testProgram)
+}
+
+func newConsoleFor(t *testing.T, source string) (*Console, *Session, *bytes.Buffer) {
+	listing := assembler.List(source)
+	if err := listing.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	program := append([]byte(vm.SledeHeader), listing.Bytecode()...)
+	session, err := NewSession(program, nil, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	return NewConsole(session, listing.Labels, &out), session, &out
+}
+
+func TestEval(t *testing.T) {
+	c, target, _ := newTestConsole(t)
+	target.vm.Regs[0] = 0x34
+	target.vm.Regs[1] = 0x02
+	target.vm.Mem[0x100] = 0x41
+
+	tests := []struct {
+		expr     string
+		expected int
+	}{
+		{"r0+r1*256", 0x234},
+		{"(r0 + r1) * 2", 0x6c},
+		{"0x10 | 1fh", 0x1f},
+		{"1 << 4 >> 2", 4},
+		{"-1 & 0xff", 0xff},
+		{"'A' - 1", 0x40},
+		{"mem[0x100]", 0x41},
+		{"*(0x80 * 2)", 0x41},
+		{"loop", 4},
+		{"store + 2", 0x14},
+		{"pc", 0},
+		{"flag", 0},
+		{"17 % 5 ^ 1", 3},
+		{"r0 == 0x34 && r1 < 3", 1},
+		{"!flag || r0 >= 0x35", 1},
+		{"r0 != 0x34 || 1 > 2", 0},
+	}
+
+	for _, tc := range tests {
+		if value, err := c.eval(tc.expr); err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+		} else if value != tc.expected {
+			t.Errorf("%s: expected 0x%x, got 0x%x", tc.expr, tc.expected, value)
+		}
+	}
+
+	for _, expr := range []string{"", "1 +", "(1", "nosuchlabel", "1 / 0", "1 $ 2"} {
+		if _, err := c.eval(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	c, target, out := newTestConsole(t)
+
+	script := []struct {
+		cmd      string
+		expected string
+	}{
+		{"break store", "Breakpoint at 0x012 <store>\n"},
+		{"run", "0x012 <store>: SETT r5, r0\n"},
+		{"info stack", "#0 0x00c <loop+8>\n"},
+		{"watch 0x200", "Watchpoint at 0x200\n"},
+		{"c", "Watchpoint 0x200 changed to 0x41\n0x01a <store+8>: PLUSS r6, r2\n"},
+		{"x/2b 0x200", "0x200: 41 00\n"},
+		{"x/1s data", "0x022 <data>: \"AB\"\n"},
+		{"x/2i loop", "0x004 <loop>: 0403  LAST r3\n0x006 <loop+2>: 0743  LIK r3, r4\n"},
+		{"delete", "Deleted all breakpoints and watchpoints\n"},
+		{"until done", "0x010 <done>: STOPP\n"},
+		{"p mem[0x201]", "= 0x42 (66)\n"},
+		{"set r3 = 'Z'", ""},
+		{"set mem[0x300]=r3+1", ""},
+		{"p *0x300", "= 0x5b (91)\n"},
+		{"step 5", "Stopped at 0x010 <done> after 33 cycles\n"},
+		{"info output", "No output\n"},
+		{"restart", "0x000: FINN 0x022\n"},
+		{"step 2", "0x004 <loop>: LAST r3\n"},
+	}
+
+	for _, tc := range script {
+		out.Reset()
+		if err := c.Exec(tc.cmd); err != nil {
+			t.Fatalf("%s: %v", tc.cmd, err)
+		} else if out.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.cmd, tc.expected, out.String())
+		}
+	}
+
+	if target.vm.Regs[0] != 0x22 {
+		t.Errorf("Expected r0 = 0x22, got 0x%02x", target.vm.Regs[0])
+	}
+
+	for _, cmd := range []string{"bogus", "break", "clear loop", "set r16=1", "info nothing"} {
+		if err := c.Exec(cmd); err == nil {
+			t.Errorf("%q: expected an error", cmd)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	c, _, _ := newTestConsole(t)
+
+	tests := []struct {
+		line     string
+		prefix   string
+		expected string
+	}{
+		{"", "", "assert break check clear delete echo help info input print restart run savestate selfmod set step taint until unwatch watch x"},
+		{"un", "un", "until unwatch"},
+		{"break lo", "lo", "loop"},
+		{"p r1", "r1", "r1 r10 r11 r12 r13 r14 r15"},
+		{"p r0+st", "st", "store"},
+		{"info w", "w", "watchpoints"},
+	}
+
+	for _, tc := range tests {
+		prefix, candidates := c.Complete(tc.line)
+		if prefix != tc.prefix || strings.Join(candidates, " ") != tc.expected {
+			t.Errorf("%q: expected %q %q, got %q %q", tc.line, tc.prefix, tc.expected,
+				prefix, strings.Join(candidates, " "))
+		}
+	}
+}
+
+func TestExecScript(t *testing.T) {
+	c, _, out := newTestConsole(t)
+
+	script := `
+# Stop at every character
+break store
+run
+assert r3 == 'A'
+run
+assert r3 == 'B' && r6 == 1
+echo ok
+run
+assert mem[0x201] == 'C'
+echo unreachable
+`
+
+	err := c.ExecScript(strings.NewReader(script))
+	if err == nil || err.Error() != "Line 10: Assertion failed: mem[0x201] == 'C'" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "ok\nStopped at 0x010 <done> after 33 cycles\n") {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	c, target, _ := newTestConsole(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	for _, cmd := range []string{"break store", "watch 0x200", "run", "savestate " + path} {
+		if err := c.Exec(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	saved, err := vm.LoadState(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := vm.DiffStates(saved, target.vm); len(diff) != 0 {
+		t.Errorf("Expected identical states, got %v", diff)
+	}
+
+	if err := c.Exec("step 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "pc: 0x012 -> 0x016, r0: 0x22 -> 0x00, r5: 0x00 -> 0x22, cycles: 6 -> 8"
+	if diff := strings.Join(vm.DiffStates(saved, target.vm), ", "); diff != expected {
+		t.Errorf("Expected %q, got %q", expected, diff)
+	}
+}
+
+const echoProgram = `
+loop:
+    LES r2
+    LIK r2, r3
+    BHOPP done
+    SKRIV r2
+    HOPP loop
+done:
+    STOPP
+`
+
+func TestInteractiveInput(t *testing.T) {
+	c, target, out := newConsoleFor(t, echoProgram)
+	target.vm.InteractiveInput = true
+
+	script := []struct {
+		cmd      string
+		expected string
+	}{
+		{"run", "Waiting for input (use the input command)\n0x000 <loop>: LES r2\n"},
+		{"step", "Waiting for input (use the input command)\n0x000 <loop>: LES r2\n"},
+		{"input \"hi\"", "2 bytes added, 2 unread\n"},
+		{"run", "Waiting for input (use the input command)\n0x000 <loop>: LES r2\n"},
+		{"info output", "6869 \"hi\"\n"},
+		{"input 00", "1 bytes added, 1 unread\n"},
+		{"run", "Stopped at 0x00a <done> after 13 cycles\n"},
+		{"restart", "0x000 <loop>: LES r2\n"},
+		{"info input", "686900 (0/3 consumed)\n"},
+	}
+
+	for _, tc := range script {
+		out.Reset()
+		if err := c.Exec(tc.cmd); err != nil {
+			t.Fatalf("%s: %v", tc.cmd, err)
+		} else if out.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.cmd, tc.expected, out.String())
+		}
+	}
+
+	if !target.vm.InteractiveInput {
+		t.Error("Restart should keep interactive input")
+	}
+}
+
+const selfModifyingProgram = `
+    FINN patched
+    SETT r2, 0x31
+    LAGR r2
+patched:
+    SETT r2, 1
+    STOPP
+`
+
+func TestSelfModify(t *testing.T) {
+	c, target, out := newConsoleFor(t, selfModifyingProgram)
+
+	script := []struct {
+		cmd      string
+		expected string
+	}{
+		{"selfmod on", "Breaking on self-modifying code\n"},
+		{"run", "Next instruction was modified at runtime\n0x006 <patched>: SETT r3, 0x01\n"},
+		{"info modified", "0x006 <patched>: SETT r3, 0x01 (was SETT r2, 0x01)\n"},
+		{"step", "0x008 <patched+2>: STOPP\n"},
+		{"set pc = 4", ""},
+		{"set r2 = 0x41", ""},
+		{"run", "Self-modifying code: 0x004 modified executed code at 0x006 <patched>\n" +
+			"0x006 <patched>: SETT r4, 0x01\n"},
+		{"restart", "0x000: FINN 0x006\n"},
+		{"info modified", "No modified memory\n"},
+	}
+
+	for _, tc := range script {
+		out.Reset()
+		if err := c.Exec(tc.cmd); err != nil {
+			t.Fatalf("%s: %v", tc.cmd, err)
+		} else if out.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.cmd, tc.expected, out.String())
+		}
+	}
+
+	if !target.vm.BreakOnSelfModify {
+		t.Error("Restart should keep breaking on self-modifying code")
+	}
+}
+
+const uncheckedProgram = `
+    FINN buffer
+    LAST r2
+    SETT r3, 1
+    LAGR r3
+    LAST r4
+    HOPP 0xffe
+buffer:
+`
+
+func TestCheck(t *testing.T) {
+	c, _, out := newConsoleFor(t, uncheckedProgram)
+
+	script := []struct {
+		cmd      string
+		expected string
+	}{
+		{"check break", "Memory checks on, breaking on warnings\n"},
+		{"set mem[0xffe] = 0x0c", ""},
+		{"run", "Warning: 0x002 read uninitialized memory at 0x00c <buffer>\n0x004: SETT r3, 0x01\n"},
+		{"run", "Warning: PC wrapped around after 0xffe\n0x000: FINN 0x00c\n"},
+		{"set pc = buffer + 2", ""},
+		{"step", "Warning: Executing past the end of the program at 0x00e <buffer+2>\n" +
+			"Stopped at 0x00e <buffer+2> after 7 cycles\n"},
+		{"check off", "Memory checks off\n"},
+	}
+
+	for _, tc := range script {
+		out.Reset()
+		if err := c.Exec(tc.cmd); err != nil {
+			t.Fatalf("%s: %v", tc.cmd, err)
+		} else if out.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.cmd, tc.expected, out.String())
+		}
+	}
+}
+
+const checkingProgram = `
+    LES r2
+    LES r3
+    FINN key
+    LAST r4
+    XELLER r2, r4
+    LAGR r2
+    LIK r2, r3
+    BHOPP good
+    SKRIV r3
+good:
+    STOPP
+key:
+    .DATA 0x20
+`
+
+func TestTaint(t *testing.T) {
+	c, target, out := newConsoleFor(t, checkingProgram)
+	target.vm.Input = []byte("ab")
+
+	script := []struct {
+		cmd      string
+		expected string
+	}{
+		{"info taint", "Not tracking taint (taint on)\n"},
+		{"taint on", "Tracking taint\n"},
+		{"run", "Stopped at 0x012 <good> after 9 cycles\n"},
+		{"info taint", "r2   in[0]\nr3   in[1]\nflag in[0-1]\n0x014 <key> (1 bytes): in[0]\n" +
+			"Branch at 0x00e: in[0-1]\nOutput byte 0 (0x62): in[1]\n"},
+		{"restart", "0x000: LES r2\n"},
+		{"info taint", "Nothing depends on the input\n"},
+		{"step", "0x002: LES r3\n"},
+		{"taint off", "Not tracking taint\n"},
+		{"taint on", "Tracking taint (restart to include the input already read)\n"},
+	}
+
+	for _, tc := range script {
+		out.Reset()
+		if err := c.Exec(tc.cmd); err != nil {
+			t.Fatalf("%s: %v", tc.cmd, err)
+		} else if out.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.cmd, tc.expected, out.String())
+		}
+	}
+}
+
+const faultyProgram = `
+    LES r0
+    LES r1
+    .DATA 0x75, 0x00
+`
+
+func TestErrors(t *testing.T) {
+	c, target, out := newConsoleFor(t, faultyProgram)
+	target.VM().Input = []byte{1}
+
+	script := []struct {
+		cmd      string
+		expected string
+	}{
+		{"assert error == 0", ""},
+		{"run", "Error at 0x002: No more input available (no_more_input, cycle 1, word 0106: LES r1)\n"},
+		{"assert error == no_more_input", ""},
+		{"input 02", "1 bytes added, 1 unread\n"},
+		{"restart", "0x000: LES r0\n"},
+		{"run", "Error at 0x004: Unsupported ALU op 7 (bad_instruction, cycle 2, word 0075)\n"},
+		{"assert error == bad_instruction && r1 == 2", ""},
+	}
+
+	for _, tc := range script {
+		out.Reset()
+		if err := c.Exec(tc.cmd); err != nil {
+			t.Fatalf("%s: %v", tc.cmd, err)
+		} else if out.String() != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.cmd, tc.expected, out.String())
+		}
+	}
+
+	if err := c.Exec("assert error == no_more_input"); err == nil {
+		t.Error("Expected the assertion to fail")
+	}
+	if !errors.Is(target.VM().LastError, vm.ErrBadInstruction) {
+		t.Errorf("Expected ErrBadInstruction, got %v", target.VM().LastError)
+	}
+}
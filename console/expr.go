@@ -0,0 +1,275 @@
+package console
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+var (
+	tokenRe = regexp.MustCompile(`^\s*(0[xX][0-9A-Fa-f]+|[0-9][0-9A-Fa-f]*[hH]\b|[0-9]+|'.'|` +
+		`[A-Za-zÆØÅæøå_][A-Za-z0-9ÆØÅæøå_]*|<<|>>|<=|>=|==|!=|&&|\|\||[-+*/%&|^~!<>()\[\]])`)
+	regRe   = regexp.MustCompile(`^[rR]([0-9]|1[0-5])$`)
+	identRe = regexp.MustCompile(`^[A-Za-zÆØÅæøå_]`)
+)
+
+// evaluator is a recursive descent parser for C-like expressions over
+// numbers, registers (r0 - r15, pc, flag), labels, memory (mem[addr]
+// or *addr) and the kind of error stopping the VM (error, compared to
+// no_more_input, bad_instruction etc., 0 if none).
+type evaluator struct {
+	vm     *vm.VM
+	labels map[string]uint16
+
+	tokens []string
+	pos    int
+}
+
+func tokenizeExpr(s string) ([]string, error) {
+	var tokens []string
+	for strings.TrimSpace(s) != "" {
+		match := tokenRe.FindStringSubmatch(s)
+		if match == nil {
+			return nil, errors.Errorf("Unexpected input: %s", strings.TrimSpace(s))
+		}
+		tokens = append(tokens, match[1])
+		s = s[len(match[0]):]
+	}
+	return tokens, nil
+}
+
+// Eval evaluates expr in the context of m and labels.
+func Eval(m *vm.VM, labels map[string]uint16, expr string) (int, error) {
+	tokens, err := tokenizeExpr(expr)
+	if err != nil {
+		return 0, err
+	} else if len(tokens) == 0 {
+		return 0, errors.New("Expression expected")
+	}
+
+	e := &evaluator{vm: m, labels: labels, tokens: tokens}
+	value, err := e.binary(0)
+	if err != nil {
+		return 0, err
+	}
+
+	if e.pos < len(e.tokens) {
+		return 0, errors.Errorf("Unexpected %s", e.tokens[e.pos])
+	}
+	return value, nil
+}
+
+func (e *evaluator) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos]
+	}
+	return ""
+}
+
+func (e *evaluator) next() string {
+	token := e.peek()
+	e.pos++
+	return token
+}
+
+func (e *evaluator) expect(token string) error {
+	if next := e.next(); next != token {
+		return errors.Errorf("Expected %s", token)
+	}
+	return nil
+}
+
+// Binary operators by precedence, lowest first
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (e *evaluator) binary(level int) (int, error) {
+	if level == len(precedence) {
+		return e.unary()
+	}
+
+	left, err := e.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := e.peek()
+		found := false
+		for _, candidate := range precedence[level] {
+			found = found || op == candidate
+		}
+		if !found {
+			return left, nil
+		}
+		e.next()
+
+		right, err := e.binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case "||":
+			left = boolToInt(left != 0 || right != 0)
+		case "&&":
+			left = boolToInt(left != 0 && right != 0)
+		case "==":
+			left = boolToInt(left == right)
+		case "!=":
+			left = boolToInt(left != right)
+		case "<":
+			left = boolToInt(left < right)
+		case "<=":
+			left = boolToInt(left <= right)
+		case ">":
+			left = boolToInt(left > right)
+		case ">=":
+			left = boolToInt(left >= right)
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, errors.New("Division by zero")
+			} else if op == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (e *evaluator) unary() (int, error) {
+	switch e.peek() {
+	case "-", "~", "!", "*":
+		op := e.next()
+		value, err := e.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "-":
+			return -value, nil
+		case "~":
+			return ^value, nil
+		case "!":
+			return boolToInt(value == 0), nil
+		default:
+			return int(e.vm.GetByte(uint16(value))), nil
+		}
+	}
+	return e.primary()
+}
+
+func (e *evaluator) primary() (int, error) {
+	token := e.next()
+	lower := strings.ToLower(token)
+
+	switch {
+	case token == "":
+		return 0, errors.New("Unexpected end of expression")
+
+	case token == "(":
+		value, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		return value, e.expect(")")
+
+	case lower == "mem" && e.peek() == "[":
+		e.next()
+		addr, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		return int(e.vm.GetByte(uint16(addr))), e.expect("]")
+
+	case len(token) == 3 && token[0] == '\'':
+		return int(token[1]), nil
+
+	case regRe.MatchString(token):
+		reg, _ := strconv.Atoi(token[1:])
+		return int(e.vm.GetReg(reg)), nil
+
+	case lower == "pc":
+		return int(e.vm.PC), nil
+
+	case lower == "flag":
+		return boolToInt(e.vm.Flag), nil
+
+	case lower == "error":
+		return int(vm.ErrorKindOf(e.vm.LastError)), nil
+	}
+
+	if addr, found := e.labels[token]; found {
+		return int(addr), nil
+	} else if kind, found := vm.ErrorKindByName(lower); found {
+		return int(kind), nil
+	}
+
+	if identRe.MatchString(token) {
+		return 0, errors.Errorf("Unknown symbol: %s", token)
+	}
+
+	var value int64
+	var err error
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		value, err = strconv.ParseInt(lower[2:], 16, 32)
+	case strings.HasSuffix(lower, "h"):
+		value, err = strconv.ParseInt(lower[:len(lower)-1], 16, 32)
+	default:
+		value, err = strconv.ParseInt(lower, 10, 32)
+	}
+	if err != nil {
+		return 0, errors.Errorf("Bad number: %s", token)
+	}
+	return int(value), nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ParseBytes accepts either a hex string ("41 42 ff") or anything
+// .DATA does ("AB", 'c', 10).
+func ParseBytes(s string) ([]byte, error) {
+	if data, err := hex.DecodeString(strings.Join(strings.Fields(s), "")); err == nil {
+		return data, nil
+	}
+	return assembler.AssembleLine(".DATA " + s)
+}
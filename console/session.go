@@ -0,0 +1,51 @@
+package console
+
+import (
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// Session is a headless Target, e.g. for running scripts without the UI.
+type Session struct {
+	program    []byte
+	cycleLimit int
+
+	vm *vm.VM
+}
+
+func NewSession(program, input []byte, cycleLimit int) (*Session, error) {
+	s := &Session{
+		program:    program,
+		cycleLimit: cycleLimit,
+	}
+
+	var err error
+	if s.vm, err = vm.NewVM(program, input, cycleLimit); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Session) VM() *vm.VM {
+	return s.vm
+}
+
+func (s *Session) Step() error {
+	return s.vm.Step()
+}
+
+func (s *Session) Run() error {
+	return s.vm.Run()
+}
+
+// Restart reloads the program, the (possibly edited) input and the VM
+// settings are kept.
+func (s *Session) Restart() error {
+	newVM, err := vm.NewVM(s.program, append([]byte{}, s.vm.Input...), s.cycleLimit)
+	if err != nil {
+		return err
+	}
+
+	newVM.KeepSettings(s.vm)
+	s.vm = newVM
+	return nil
+}
@@ -0,0 +1,117 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+const (
+	// Number of items listed in the status bar summary
+	maxChangesInSummary = 4
+)
+
+// Colors of values changed 0, 1, 2... stops ago
+var changeColors = []string{"yellow", "gold", "darkgoldenrod"}
+
+// ChangeTracker remembers at which stop (step or run) each register and
+// memory byte was last changed, so that the panes can highlight them.
+type ChangeTracker struct {
+	stops int
+	fade  bool
+
+	prevRegs [vm.RegCount]byte
+	prevFlag bool
+	prevMem  [vm.MemSize]byte
+
+	// Stop numbers, 0 means "never changed"
+	regs [vm.RegCount]int
+	flag int
+	mem  [vm.MemSize]int
+}
+
+func NewChangeTracker(fade bool) *ChangeTracker {
+	return &ChangeTracker{fade: fade}
+}
+
+// Before has to be called before the VM is stepped or run.
+func (ct *ChangeTracker) Before(m *vm.VM) {
+	ct.prevRegs = m.Regs
+	ct.prevFlag = m.Flag
+	ct.prevMem = m.Mem
+}
+
+// After records changes made since Before and summarizes them.
+func (ct *ChangeTracker) After(m *vm.VM) string {
+	ct.stops++
+
+	var changes []string
+	for i := range m.Regs {
+		if m.Regs[i] != ct.prevRegs[i] {
+			ct.regs[i] = ct.stops
+			changes = append(changes, fmt.Sprintf("r%d %02x→%02x", i, ct.prevRegs[i], m.Regs[i]))
+		}
+	}
+
+	if m.Flag != ct.prevFlag {
+		ct.flag = ct.stops
+		changes = append(changes, fmt.Sprintf("Flag %v→%v", ct.prevFlag, m.Flag))
+	}
+
+	for i := range m.Mem {
+		if m.Mem[i] != ct.prevMem[i] {
+			ct.mem[i] = ct.stops
+			changes = append(changes, fmt.Sprintf("[0x%03x] %02x→%02x", i, ct.prevMem[i], m.Mem[i]))
+		}
+	}
+
+	if len(changes) == 0 {
+		return "No changes"
+	} else if len(changes) > maxChangesInSummary {
+		more := len(changes) - maxChangesInSummary
+		changes = append(changes[:maxChangesInSummary], fmt.Sprintf("(+%d more)", more))
+	}
+	return "Changed: " + strings.Join(changes, ", ")
+}
+
+func (ct *ChangeTracker) ToggleFade() {
+	ct.fade = !ct.fade
+}
+
+func (ct *ChangeTracker) color(stop int) string {
+	if stop == 0 {
+		return ""
+	}
+
+	age := ct.stops - stop
+	if age == 0 || (ct.fade && age < len(changeColors)) {
+		return changeColors[age]
+	}
+	return ""
+}
+
+// RegTag returns a tview color tag for the register value, or "".
+func (ct *ChangeTracker) RegTag(reg int) string {
+	if color := ct.color(ct.regs[reg]); color != "" {
+		return "[" + color + "::b]"
+	}
+	return ""
+}
+
+func (ct *ChangeTracker) FlagTag() string {
+	if color := ct.color(ct.flag); color != "" {
+		return "[" + color + "::b]"
+	}
+	return ""
+}
+
+// MemStyle applies the highlight (if any) of the byte at addr to style.
+func (ct *ChangeTracker) MemStyle(addr uint16, style tcell.Style) tcell.Style {
+	if color := ct.color(ct.mem[addr%vm.MemSize]); color != "" {
+		return style.Foreground(tcell.GetColor(color)).Bold(true)
+	}
+	return style
+}
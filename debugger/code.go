@@ -65,8 +65,18 @@ func (cv *CodeView) Draw(screen tcell.Screen) {
 			color = "[:red:b]"
 		}
 
-		_, printedWidth := tview.Print(screen, fmt.Sprintf("%s%s%03x: %02x%02x  %s",
-			color, symbol, offset, instr.Raw&0xff, instr.Raw>>8, instr.String()),
+		// Changed at runtime, e.g. by a decryption loop
+		var note string
+		if cv.ui.vm.Modified(offset) || cv.ui.vm.Modified(offset+1) {
+			original := vm.ParseInstruction(cv.ui.vm.OriginalWord(offset))
+			note = fmt.Sprintf("  [fuchsia]✎ was %s", original)
+		}
+		if taint := cv.ui.vm.BranchTaint(offset); len(taint) > 0 {
+			note += fmt.Sprintf("  [aqua]⑂ %s", taint)
+		}
+
+		_, printedWidth := tview.Print(screen, fmt.Sprintf("%s%s%03x: %02x%02x  %s%s",
+			color, symbol, offset, instr.Raw&0xff, instr.Raw>>8, instr.String(), note),
 			x, y+i, width, tview.AlignLeft, 0)
 
 		if color != "" {
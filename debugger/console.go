@@ -0,0 +1,143 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/upryst/slede8dbg/console"
+)
+
+type ConsoleView struct {
+	*tview.Flex
+	ui *UI
+
+	log     *tview.TextView
+	prompt  *tview.InputField
+	console *console.Console
+
+	history      []string
+	historyIndex int
+}
+
+func NewConsoleView(ui *UI, labels map[string]uint16) *ConsoleView {
+	cv := &ConsoleView{
+		Flex:   tview.NewFlex().SetDirection(tview.FlexRow),
+		ui:     ui,
+		log:    tview.NewTextView(),
+		prompt: tview.NewInputField(),
+	}
+	cv.console = console.NewConsole(ui, labels, cv.log)
+
+	cv.log.SetWrap(false)
+	cv.log.SetChangedFunc(func() { cv.log.ScrollToEnd() })
+
+	cv.prompt.SetLabel("> ")
+	cv.prompt.SetFieldBackgroundColor(tcell.ColorBlack)
+	cv.prompt.SetInputCapture(cv.handleKey)
+
+	cv.AddItem(cv.log, 0, 1, false).
+		AddItem(cv.prompt, 1, 0, true)
+	cv.SetBorder(true).SetTitle(" Console ").SetTitleAlign(tview.AlignLeft)
+
+	return cv
+}
+
+// Exec runs a command line, echoing it and any error to the log.
+func (cv *ConsoleView) Exec(line string) {
+	fmt.Fprintf(cv.log, "> %s\n", line)
+	if err := cv.console.Exec(line); err != nil {
+		fmt.Fprintf(cv.log, "Error: %v\n", err)
+	}
+}
+
+// ExecScript runs commands line by line, stopping at the first error.
+func (cv *ConsoleView) ExecScript(script string) {
+	for i, line := range strings.Split(script, "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+
+		fmt.Fprintf(cv.log, "> %s\n", line)
+		if err := cv.console.Exec(line); err != nil {
+			fmt.Fprintf(cv.log, "Error: Line %d: %v\n", i+1, err)
+			return
+		}
+	}
+}
+
+func (cv *ConsoleView) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyEnter:
+		line := cv.prompt.GetText()
+		if strings.TrimSpace(line) != "" {
+			cv.history = append(cv.history, line)
+		}
+		cv.historyIndex = len(cv.history)
+		cv.prompt.SetText("")
+		cv.Exec(line)
+		cv.ui.Refresh()
+
+	case tcell.KeyUp:
+		if cv.historyIndex > 0 {
+			cv.historyIndex--
+			cv.prompt.SetText(cv.history[cv.historyIndex])
+		}
+
+	case tcell.KeyDown:
+		if cv.historyIndex < len(cv.history)-1 {
+			cv.historyIndex++
+			cv.prompt.SetText(cv.history[cv.historyIndex])
+		} else {
+			cv.historyIndex = len(cv.history)
+			cv.prompt.SetText("")
+		}
+
+	case tcell.KeyTab:
+		cv.complete()
+
+	default:
+		return event
+	}
+
+	return nil
+}
+
+// complete extends the prompt with the longest common prefix of all
+// candidates, listing them if it's ambiguous.
+func (cv *ConsoleView) complete() {
+	line := cv.prompt.GetText()
+	prefix, candidates := cv.console.Complete(line)
+	if len(candidates) == 0 {
+		return
+	}
+
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	if len(candidates) == 1 {
+		common += " "
+	} else if common == prefix {
+		fmt.Fprintf(cv.log, "%s\n", strings.Join(candidates, " "))
+	}
+
+	cv.prompt.SetText(line[:len(line)-len(prefix)] + common)
+}
+
+func (cv *ConsoleView) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return cv.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		consumed, capture = cv.Flex.MouseHandler()(action, event, setFocus)
+
+		// Keep the focus on the prompt, the log is only scrollable
+		if x, y := event.Position(); action == tview.MouseLeftClick && cv.InRect(x, y) {
+			setFocus(cv.prompt)
+		}
+		return
+	})
+}
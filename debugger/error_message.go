@@ -1,13 +1,18 @@
 package debugger
 
 import (
+	"fmt"
+
 	"github.com/gdamore/tcell/v2"
+	"github.com/pkg/errors"
 	"github.com/rivo/tview"
+
+	"github.com/upryst/slede8dbg/vm"
 )
 
 const (
 	errorDialogWidth  = 50
-	errorDialogHeight = 5
+	errorDialogHeight = 8
 )
 
 type ErrorMessage struct {
@@ -25,7 +30,7 @@ func (ui *UI) ShowError() {
 	}
 
 	errBox := &ErrorMessage{tview.NewTextView(), ui}
-	errBox.SetText(ui.vm.LastError.Error()).
+	errBox.SetText(describeError(ui.vm.LastError)).
 		SetDynamicColors(true).
 		SetTitle(" Error ").
 		SetBackgroundColor(tcell.ColorRed).
@@ -48,6 +53,16 @@ func (ui *UI) ShowError() {
 	})
 }
 
+// describeError puts the details of a VMError on separate lines.
+func describeError(err error) string {
+	var vmErr *vm.VMError
+	if !errors.As(err, &vmErr) {
+		return tview.Escape(err.Error())
+	}
+	return tview.Escape(fmt.Sprintf("%v\n\n%s at 0x%03x, cycle %d\n%s",
+		vmErr.Err, vmErr.Kind, vmErr.PC, vmErr.Cycle, vmErr.Describe()))
+}
+
 func (em *ErrorMessage) Close() {
 	em.ui.pages.RemovePage("error")
 	em.ui.pages.SwitchToPage("main")
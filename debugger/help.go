@@ -14,15 +14,41 @@ Keyboard shortcuts:
 [green:-:b]Alt-2[-:-:-]  switch to Memory
 [green:-:b]Alt-3[-:-:-]  switch to Registers
 [green:-:b]Alt-4[-:-:-]  switch to Output
+[green:-:b]Alt-5[-:-:-]  switch to Console (type [green:-:b]help[-:-:-] there)
 [green:-:b]Enter[-:-:-]  Assembler mode (beta)
+[green:-:b]Ctrl-P[-:-:-] Toggle decompiled pseudocode pane
 
 [green:-:b]F1[-:-:-]   Help screen
+[green:-:b]F2[-:-:-]   Toggle taint tracking (console: [green:-:b]info taint[-:-:-])
+[green:-:b]F3[-:-:-]   Memory checks: off, warn, break
 [green:-:b]F4[-:-:-]   Toggle output window mode (Hex, ASCII)
 [green:-:b]F5[-:-:-]   Run
+[green:-:b]F6[-:-:-]   Toggle fading of change highlights
+[green:-:b]F7[-:-:-]   Toggle interactive input (LES prompts when empty)
+[green:-:b]F8[-:-:-]   Toggle breaking on self-modifying code
 [green:-:b]F9[-:-:-]   Toggle break point
 [green:-:b]F10[-:-:-]  Step
 
 
+Memory pane:
+
+[green:-:b]Arrows[-:-:-]  move cursor, [green:-:b]Shift[-:-:-] extends selection
+[green:-:b]Tab[-:-:-]     switch between hex and ASCII column
+[green:-:b]0-9 a-f[-:-:-] overwrite (hex column), any char in ASCII
+[green:-:b]Enter[-:-:-]   enter bytes (hex or .DATA syntax)
+[green:-:b]Ctrl-G[-:-:-]  go to address
+[green:-:b]Ctrl-F[-:-:-]  fill selection
+[green:-:b]Ctrl-K[-:-:-]  copy selection, [green:-:b]Ctrl-V[-:-:-] paste
+
+Input pane:
+
+[green:-:b]Enter[-:-:-]   edit unread input (hex or .DATA syntax)
+
+Registers pane:
+
+[green:-:b]Arrows[-:-:-]  select register, PC or Flag
+[green:-:b]Enter[-:-:-]   edit value (toggles Flag)
+
 [green:-:b]Ctrl-C[-:-:-]         Quit
 [green:-:b]Ctrl-Shift-F5[-:-:-]  Restart debugging from scratch
 
@@ -31,8 +57,8 @@ Press [green:-:b]Esc[-:-:-] or [green:-:b]Enter[-:-:-]
 )
 
 const (
-	helpViewWidth  = 50
-	helpViewHeight = 24
+	helpViewWidth  = 56
+	helpViewHeight = 50
 )
 
 type HelpView struct {
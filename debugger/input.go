@@ -1,20 +1,30 @@
 package debugger
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/rivo/tview"
+
+	"github.com/upryst/slede8dbg/console"
 )
 
 func NewInputView() *tview.TextView {
 	iv := tview.NewTextView()
 	iv.SetDynamicColors(true)
-	iv.SetBorder(true).SetTitle(" Input ").SetTitleAlign(tview.AlignLeft)
+	iv.SetBorder(true).SetTitleAlign(tview.AlignLeft)
 	return iv
 }
 
 func (ui *UI) UpdateInput() {
+	if ui.vm.InteractiveInput {
+		ui.input.SetTitle(" Input (interactive) ")
+	} else {
+		ui.input.SetTitle(" Input ")
+	}
+
 	var text strings.Builder
 	for i := range ui.vm.Input {
 		if i == ui.vm.InputIndex {
@@ -27,3 +37,45 @@ func (ui *UI) UpdateInput() {
 	}
 	ui.input.SetText(text.String())
 }
+
+// EditInput replaces the unread part of the input.
+func (ui *UI) EditInput() {
+	unread := hex.EncodeToString(ui.vm.Input[ui.vm.InputIndex:])
+	ui.ShowPrompt("Unread input (hex or .DATA)", unread, func(text string) error {
+		data, err := console.ParseBytes(text)
+		if err != nil {
+			return err
+		}
+
+		index := ui.vm.InputIndex
+		ui.vm.Input = append(ui.vm.Input[:index:index], data...)
+		return nil
+	})
+}
+
+// PromptForInput asks for the bytes LES is waiting for in interactive
+// mode, then resumes the interrupted step or run.
+func (ui *UI) PromptForInput(resume func()) {
+	ui.ShowPrompt("LES needs input (hex or .DATA)", "", func(text string) error {
+		data, err := console.ParseBytes(text)
+		if err != nil {
+			return err
+		} else if len(data) == 0 {
+			return errors.New("Input expected")
+		}
+
+		ui.vm.Input = append(ui.vm.Input, data...)
+		// After the prompt has been closed
+		go ui.app.QueueUpdateDraw(resume)
+		return nil
+	})
+}
+
+func (ui *UI) ToggleInteractiveInput() {
+	ui.vm.InteractiveInput = !ui.vm.InteractiveInput
+	if ui.vm.InteractiveInput {
+		ui.status.SetInfoText("Interactive input: LES pauses when input runs out")
+	} else {
+		ui.status.SetInfoText("Interactive input off")
+	}
+}
@@ -2,14 +2,21 @@ package debugger
 
 import (
 	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
 )
 
 func (ui *UI) HandleKeyboard(event *tcell.EventKey) *tcell.EventKey {
+	ui.status.ClearInfoText()
+
 	switch event.Key() {
 	case tcell.KeyF1:
 		ui.ShowHelp()
 	case tcell.KeyF10:
 		ui.StepVM()
+	case tcell.KeyF2:
+		ui.ToggleTaint()
+	case tcell.KeyF3:
+		ui.CycleMemoryChecks()
 	case tcell.KeyF4:
 		ui.code.ui.ToggleASCIIMode()
 	case tcell.KeyF5:
@@ -18,24 +25,49 @@ func (ui *UI) HandleKeyboard(event *tcell.EventKey) *tcell.EventKey {
 		} else {
 			ui.RunVM()
 		}
+	case tcell.KeyF6:
+		ui.changes.ToggleFade()
+	case tcell.KeyF7:
+		ui.ToggleInteractiveInput()
+	case tcell.KeyF8:
+		ui.ToggleBreakOnSelfModify()
 	case tcell.KeyF9:
 		ui.ToggleBreakpoint()
+	case tcell.KeyCtrlP:
+		ui.TogglePseudocode()
 	case tcell.KeyEnter:
-		ui.ShowAsm()
+		// Memory, Registers and Console panes handle Enter themselves,
+		// on Input it edits the unread bytes
+		switch ui.app.GetFocus() {
+		case ui.memory, ui.registers, ui.console.prompt:
+		case ui.input:
+			ui.EditInput()
+		default:
+			ui.ShowAsm()
+		}
 	}
 
 	if event.Modifiers()&tcell.ModAlt != 0 {
+		var pane tview.Primitive
 		switch event.Rune() {
 		case '0':
-			ui.app.SetFocus(ui.input)
+			pane = ui.input
 		case '1':
-			ui.app.SetFocus(ui.code)
+			pane = ui.code
 		case '2':
-			ui.app.SetFocus(ui.memory)
+			pane = ui.memory
 		case '3':
-			ui.app.SetFocus(ui.registers)
+			pane = ui.registers
 		case '4':
-			ui.app.SetFocus(ui.output)
+			pane = ui.output
+		case '5':
+			pane = ui.console
+		}
+
+		if pane != nil {
+			ui.app.SetFocus(pane)
+			// Don't let the newly focused pane handle the event
+			event = nil
 		}
 	}
 
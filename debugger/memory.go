@@ -2,16 +2,29 @@ package debugger
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/pkg/errors"
 	"github.com/rivo/tview"
 
+	"github.com/upryst/slede8dbg/console"
 	"github.com/upryst/slede8dbg/vm"
 )
 
 const (
 	MemSize = vm.MemSize
+
+	// "  000: " precedes the hex column
+	memHexColumnOffset = 7
+)
+
+var (
+	memCursorStyle         = tcell.StyleDefault.Background(tcell.ColorGray).Bold(true)
+	memInactiveCursorStyle = tcell.StyleDefault.Underline(true)
+	memSelectionStyle      = tcell.StyleDefault.Background(tcell.ColorNavy)
+	memAddressStyle        = tcell.StyleDefault.Foreground(tcell.ColorGreen)
+	memUninitializedStyle  = tcell.StyleDefault.Foreground(tcell.ColorDimGray)
+	memTaintedStyle        = tcell.StyleDefault.Underline(true)
 )
 
 type MemoryView struct {
@@ -20,12 +33,23 @@ type MemoryView struct {
 
 	offset       uint16
 	bytesPerLine int
+	height       int
+
+	cursor    uint16
+	ascii     bool // cursor is in the ASCII column
+	lowNibble bool // next hex digit goes to the low nibble
+
+	selecting bool
+	anchor    uint16
+
+	clipboard []byte
 }
 
 func NewMemoryView(ui *UI) *MemoryView {
 	mv := &MemoryView{
-		TextView: tview.NewTextView(),
-		ui:       ui,
+		TextView:     tview.NewTextView(),
+		ui:           ui,
+		bytesPerLine: 8,
 	}
 	mv.SetWrap(false)
 	mv.SetDynamicColors(true)
@@ -37,48 +61,260 @@ func (mv *MemoryView) Scroll(lines int) {
 	mv.offset = (mv.offset + uint16(mv.bytesPerLine*lines)) % MemSize
 }
 
+// Selection returns the selected range, or just the byte under the cursor.
+func (mv *MemoryView) Selection() (start, end uint16) {
+	if !mv.selecting {
+		return mv.cursor, mv.cursor
+	}
+	if mv.anchor < mv.cursor {
+		return mv.anchor, mv.cursor
+	}
+	return mv.cursor, mv.anchor
+}
+
+func (mv *MemoryView) selected(addr uint16) bool {
+	start, end := mv.Selection()
+	return mv.selecting && addr >= start && addr <= end
+}
+
+// MoveCursor moves the cursor by delta bytes, scrolling if necessary.
+// With extend the selection is grown instead of cleared.
+func (mv *MemoryView) MoveCursor(delta int, extend bool) {
+	if extend && !mv.selecting {
+		mv.selecting = true
+		mv.anchor = mv.cursor
+	} else if !extend {
+		mv.selecting = false
+	}
+
+	mv.SetCursor(uint16(int(mv.cursor)+delta+MemSize)%MemSize, delta < 0)
+}
+
+// SetCursor places the cursor at addr, if it isn't visible the view is
+// scrolled so that addr is on the top (or bottom) line.
+func (mv *MemoryView) SetCursor(addr uint16, top bool) {
+	mv.cursor = addr % MemSize
+	mv.lowNibble = false
+
+	visible := uint16(mv.bytesPerLine * mv.height)
+	if (mv.cursor-mv.offset)%MemSize < visible {
+		return
+	}
+
+	lineStart := mv.cursor - (mv.cursor-mv.offset)%MemSize%uint16(mv.bytesPerLine)
+	if top || mv.height == 0 {
+		mv.offset = lineStart % MemSize
+	} else {
+		mv.offset = (lineStart - uint16(mv.bytesPerLine*(mv.height-1))) % MemSize
+	}
+}
+
+// Write stores data at addr and moves the cursor past it.
+func (mv *MemoryView) Write(addr uint16, data []byte) {
+	for i, b := range data {
+		mv.ui.vm.SetByte(addr+uint16(i), b)
+	}
+	mv.selecting = false
+	mv.SetCursor(addr+uint16(len(data)), false)
+}
+
+func (mv *MemoryView) Copy() {
+	start, end := mv.Selection()
+	mv.clipboard = make([]byte, 0, end-start+1)
+	for addr := start; addr <= end; addr++ {
+		mv.clipboard = append(mv.clipboard, mv.ui.vm.GetByte(addr))
+	}
+	mv.selecting = false
+	mv.ui.status.SetInfoText(fmt.Sprintf("Copied %d byte(s) from 0x%03x", len(mv.clipboard), start))
+}
+
+func (mv *MemoryView) Paste() {
+	if len(mv.clipboard) == 0 {
+		mv.ui.status.SetErrorText("Clipboard is empty")
+		return
+	}
+	mv.ui.status.SetInfoText(fmt.Sprintf("Pasted %d byte(s) at 0x%03x", len(mv.clipboard), mv.cursor))
+	mv.Write(mv.cursor, mv.clipboard)
+}
+
+func (mv *MemoryView) ShowGoto() {
+	mv.ui.ShowPrompt("Go to address", fmt.Sprintf("0x%03x", mv.cursor), func(text string) error {
+		addr, err := parseValue(text, 16)
+		if err != nil {
+			return err
+		} else if addr >= MemSize {
+			return errors.Errorf("Address out of range: 0x%x", addr)
+		}
+		mv.selecting = false
+		mv.SetCursor(uint16(addr), true)
+		return nil
+	})
+}
+
+func (mv *MemoryView) ShowFill() {
+	start, end := mv.Selection()
+	title := fmt.Sprintf("Fill 0x%03x-0x%03x with", start, end)
+	mv.ui.ShowPrompt(title, "0x00", func(text string) error {
+		value, err := parseValue(text, 8)
+		if err != nil {
+			return err
+		}
+		for addr := start; addr <= end; addr++ {
+			mv.ui.vm.SetByte(addr, byte(value))
+		}
+		mv.selecting = false
+		return nil
+	})
+}
+
+func (mv *MemoryView) ShowEnterBytes() {
+	title := fmt.Sprintf("Bytes at 0x%03x (hex or .DATA)", mv.cursor)
+	mv.ui.ShowPrompt(title, "", func(text string) error {
+		data, err := console.ParseBytes(text)
+		if err != nil {
+			return err
+		}
+		mv.Write(mv.cursor, data)
+		return nil
+	})
+}
+
+func (mv *MemoryView) typeHex(digit byte) {
+	b := mv.ui.vm.GetByte(mv.cursor)
+	if mv.lowNibble {
+		mv.ui.vm.SetByte(mv.cursor, b&0xf0|digit)
+		mv.MoveCursor(1, false)
+	} else {
+		mv.ui.vm.SetByte(mv.cursor, b&0x0f|digit<<4)
+		mv.lowNibble = true
+	}
+}
+
+func (mv *MemoryView) styleOf(addr uint16, asciiColumn bool) tcell.Style {
+	if addr == mv.cursor {
+		if mv.HasFocus() && asciiColumn == mv.ascii {
+			return memCursorStyle
+		}
+		return memInactiveCursorStyle
+	}
+	if mv.selected(addr) {
+		return mv.ui.changes.MemStyle(addr, memSelectionStyle)
+	}
+	if mv.ui.vm.CheckMemory && !mv.ui.vm.Initialized(addr) {
+		return mv.ui.changes.MemStyle(addr, memUninitializedStyle)
+	}
+	if len(mv.ui.vm.MemTaint(addr)) > 0 {
+		return mv.ui.changes.MemStyle(addr, memTaintedStyle)
+	}
+	return mv.ui.changes.MemStyle(addr, tcell.StyleDefault)
+}
+
+func printCells(screen tcell.Screen, x, y, maxX int, s string, style tcell.Style) int {
+	for _, r := range s {
+		if x >= maxX {
+			break
+		}
+		screen.SetContent(x, y, r, nil, style)
+		x++
+	}
+	return x
+}
+
 func (mv *MemoryView) Draw(screen tcell.Screen) {
-	_, _, width, height := mv.TextView.GetInnerRect()
+	if taint := mv.ui.vm.MemTaint(mv.cursor); len(taint) > 0 {
+		mv.SetTitle(fmt.Sprintf(" Memory · 0x%03x ← %s ", mv.cursor, taint))
+	} else {
+		mv.SetTitle(" Memory ")
+	}
+
+	mv.TextView.DrawForSubclass(screen, mv)
+	x, y, width, height := mv.TextView.GetInnerRect()
 
 	if width > 16*4+20 {
 		mv.bytesPerLine = 16
 	} else {
 		mv.bytesPerLine = 8
 	}
+	mv.height = height
 
-	var text strings.Builder
+	maxX := x + width
 	for i := 0; i < height; i++ {
-		text.WriteString(fmt.Sprintf("  %03x: ", (mv.offset+uint16(i*mv.bytesPerLine))%MemSize))
-		for j := 0; j < mv.bytesPerLine; j++ {
-			text.WriteString(fmt.Sprintf("%02x ", mv.ui.vm.GetByte((mv.offset+uint16(i*mv.bytesPerLine+j))%MemSize)))
-		}
-		text.WriteString("  ")
+		lineAddr := (mv.offset + uint16(i*mv.bytesPerLine)) % MemSize
+		cx := printCells(screen, x+2, y+i, maxX, fmt.Sprintf("%03x:", lineAddr), memAddressStyle)
+		cx++
+
+		asciiX := x + memHexColumnOffset + mv.bytesPerLine*3 + 2
 		for j := 0; j < mv.bytesPerLine; j++ {
-			b := mv.ui.vm.GetByte((mv.offset + uint16(i*mv.bytesPerLine+j)) % MemSize)
+			addr := (lineAddr + uint16(j)) % MemSize
+			b := mv.ui.vm.GetByte(addr)
+
+			cx = printCells(screen, cx, y+i, maxX, fmt.Sprintf("%02x", b), mv.styleOf(addr, false))
+			if mv.selected(addr) && mv.selected(addr+1) && j+1 < mv.bytesPerLine {
+				printCells(screen, cx, y+i, maxX, " ", memSelectionStyle)
+			}
+			cx++
+
+			char := '.'
 			if b >= ' ' && b < 0x80 {
-				text.WriteByte(b)
-			} else {
-				text.WriteByte('.')
+				char = rune(b)
 			}
+			printCells(screen, asciiX+j, y+i, maxX, string(char), mv.styleOf(addr, true))
 		}
-		text.WriteByte('\n')
 	}
-	mv.SetText(text.String())
-	mv.TextView.Draw(screen)
 }
 
 func (mv *MemoryView) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
 	return mv.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
-		_, _, _, height := mv.GetInnerRect()
+		extend := event.Modifiers()&tcell.ModShift != 0
+		page := mv.bytesPerLine * mv.height
+
 		switch event.Key() {
+		case tcell.KeyLeft:
+			mv.MoveCursor(-1, extend)
+		case tcell.KeyRight:
+			mv.MoveCursor(1, extend)
 		case tcell.KeyUp:
-			mv.Scroll(-1)
+			mv.MoveCursor(-mv.bytesPerLine, extend)
 		case tcell.KeyDown:
-			mv.Scroll(1)
+			mv.MoveCursor(mv.bytesPerLine, extend)
 		case tcell.KeyPgUp:
-			mv.Scroll(-height)
+			mv.Scroll(-mv.height)
+			mv.MoveCursor(-page, extend)
 		case tcell.KeyPgDn:
-			mv.Scroll(height)
+			mv.Scroll(mv.height)
+			mv.MoveCursor(page, extend)
+		case tcell.KeyHome:
+			mv.MoveCursor(-int((mv.cursor-mv.offset)%MemSize%uint16(mv.bytesPerLine)), extend)
+		case tcell.KeyEnd:
+			mv.MoveCursor(mv.bytesPerLine-1-int((mv.cursor-mv.offset)%MemSize%uint16(mv.bytesPerLine)), extend)
+		case tcell.KeyTab, tcell.KeyBacktab:
+			mv.ascii = !mv.ascii
+			mv.lowNibble = false
+		case tcell.KeyEscape:
+			mv.selecting = false
+		case tcell.KeyEnter:
+			mv.ShowEnterBytes()
+		case tcell.KeyCtrlG:
+			mv.ShowGoto()
+		case tcell.KeyCtrlF:
+			mv.ShowFill()
+		case tcell.KeyCtrlK:
+			mv.Copy()
+		case tcell.KeyCtrlV:
+			mv.Paste()
+		case tcell.KeyRune:
+			if event.Modifiers()&tcell.ModAlt != 0 {
+				return
+			}
+			r := event.Rune()
+			if mv.ascii {
+				if r >= ' ' && r < 0x80 {
+					mv.ui.vm.SetByte(mv.cursor, byte(r))
+					mv.MoveCursor(1, false)
+				}
+			} else if digit, err := parseValue(fmt.Sprintf("0x%c", r), 8); err == nil {
+				mv.typeHex(byte(digit))
+			}
 		}
 	})
 }
@@ -92,7 +328,8 @@ func (mv *MemoryView) MouseHandler() func(action tview.MouseAction, event *tcell
 
 		switch action {
 		case tview.MouseLeftClick:
-			setFocus(mv.TextView)
+			setFocus(mv)
+			mv.clickAt(x, y)
 		case tview.MouseScrollUp:
 			mv.Scroll(-1)
 		case tview.MouseScrollDown:
@@ -106,3 +343,24 @@ func (mv *MemoryView) MouseHandler() func(action tview.MouseAction, event *tcell
 		return
 	})
 }
+
+func (mv *MemoryView) clickAt(x, y int) {
+	innerX, innerY, _, _ := mv.GetInnerRect()
+	col, row := x-innerX, y-innerY
+
+	hexStart := memHexColumnOffset
+	asciiStart := memHexColumnOffset + mv.bytesPerLine*3 + 2
+
+	var index int
+	switch {
+	case col >= hexStart && col < hexStart+mv.bytesPerLine*3:
+		index, mv.ascii = (col-hexStart)/3, false
+	case col >= asciiStart && col < asciiStart+mv.bytesPerLine:
+		index, mv.ascii = col-asciiStart, true
+	default:
+		return
+	}
+
+	mv.selecting = false
+	mv.SetCursor(mv.offset+uint16(row*mv.bytesPerLine+index), false)
+}
@@ -1,6 +1,12 @@
 package debugger
 
-import "github.com/rivo/tview"
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rivo/tview"
+)
 
 func makeModal(p tview.Primitive, width, height int) tview.Primitive {
 	return tview.NewGrid().
@@ -8,3 +14,27 @@ func makeModal(p tview.Primitive, width, height int) tview.Primitive {
 		SetRows(0, height, 0).
 		AddItem(p, 1, 1, 1, 1, 0, 0, true)
 }
+
+// parseValue accepts the same number formats as the assembler:
+// 0x1f, 1fh, 31 and 'c'.
+func parseValue(s string, bits int) (uint64, error) {
+	s = strings.TrimSpace(s)
+
+	var value uint64
+	var err error
+	switch {
+	case len(s) == 3 && s[0] == '\'' && s[2] == '\'':
+		value = uint64(s[1])
+	case len(s) > 1 && (s[len(s)-1] == 'h' || s[len(s)-1] == 'H'):
+		value, err = strconv.ParseUint(s[:len(s)-1], 16, bits)
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		value, err = strconv.ParseUint(s[2:], 16, bits)
+	default:
+		value, err = strconv.ParseUint(s, 10, bits)
+	}
+
+	if err != nil {
+		return 0, errors.Errorf("Bad value: %s", s)
+	}
+	return value, nil
+}
@@ -44,7 +44,12 @@ func (ui *UI) ToggleASCIIMode() {
 func (ui *UI) UpdateOutput() {
 	var text strings.Builder
 
-	for _, b := range ui.vm.Output {
+	for i, b := range ui.vm.Output {
+		tainted := len(ui.vm.OutputTaint(i)) > 0
+		if tainted {
+			text.WriteString("[::u]")
+		}
+
 		if ui.output.ascii {
 			if b >= ' ' && b < 0x80 {
 				text.WriteByte(b)
@@ -54,6 +59,10 @@ func (ui *UI) UpdateOutput() {
 		} else {
 			text.WriteString(fmt.Sprintf("%02x", b))
 		}
+
+		if tainted {
+			text.WriteString("[::-]")
+		}
 	}
 
 	ui.output.SetText(text.String())
@@ -0,0 +1,81 @@
+package debugger
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	promptDialogWidth  = 60
+	promptDialogHeight = 5
+)
+
+// PromptView is a single line input dialog, done is called on Enter and
+// the dialog stays open for corrections if it returns an error.
+type PromptView struct {
+	*tview.Form
+
+	edit     *tview.InputField
+	ui       *UI
+	previous tview.Primitive
+}
+
+func (ui *UI) ShowPrompt(title, text string, done func(text string) error) {
+	pv := &PromptView{
+		Form:     tview.NewForm(),
+		ui:       ui,
+		previous: ui.app.GetFocus(),
+	}
+
+	pv.AddInputField("", text, promptDialogWidth-6, nil, nil)
+	if edit, ok := pv.GetFormItem(0).(*tview.InputField); !ok {
+		panic("I don't know how tview works")
+	} else {
+		pv.edit = edit
+	}
+
+	pv.SetFieldBackgroundColor(tcell.ColorBlack)
+
+	pv.SetBorder(true).SetTitle(" " + title + " [ Esc - cancel ] ")
+	pv.SetTitleAlign(tview.AlignLeft)
+
+	ui.pages.AddPage("prompt", makeModal(pv, promptDialogWidth, promptDialogHeight), true, true)
+	ui.app.SetFocus(pv)
+
+	pv.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			pv.Close()
+		case tcell.KeyEnter:
+			if err := done(pv.edit.GetText()); err != nil {
+				ui.status.SetErrorText(err.Error())
+			} else {
+				pv.Close()
+			}
+			ui.Refresh()
+		default:
+			return event
+		}
+
+		return nil
+	})
+}
+
+func (pv *PromptView) Close() {
+	pv.ui.status.ClearErrorText()
+	pv.ui.pages.RemovePage("prompt")
+	pv.ui.pages.SwitchToPage("main")
+	pv.ui.app.SetFocus(pv.previous)
+}
+
+func (pv *PromptView) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return pv.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		x, y := event.Position()
+		if !pv.InRect(x, y) && action == tview.MouseLeftClick {
+			pv.Close()
+		}
+
+		consumed = true
+		return
+	})
+}
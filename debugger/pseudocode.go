@@ -0,0 +1,105 @@
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/upryst/slede8dbg/decompiler"
+)
+
+// PseudocodeView shows the decompiled function around the line highlighted
+// in the Code pane. The program is decompiled again when its code changes
+// at runtime.
+type PseudocodeView struct {
+	*tview.TextView
+	ui *UI
+
+	shown   bool
+	labels  map[string]uint16
+	code    []byte // decompiled
+	listing *decompiler.Listing
+}
+
+func NewPseudocodeView(ui *UI, labels map[string]uint16) *PseudocodeView {
+	pv := &PseudocodeView{
+		TextView: tview.NewTextView(),
+		ui:       ui,
+		labels:   labels,
+	}
+	pv.SetWrap(false)
+	pv.SetDynamicColors(true)
+	pv.SetBorder(true).SetTitle(" Pseudocode ").SetTitleAlign(tview.AlignLeft)
+	return pv
+}
+
+// TogglePseudocode shows or hides the Pseudocode pane.
+func (ui *UI) TogglePseudocode() {
+	if ui.pseudocode.shown {
+		ui.codeRow.RemoveItem(ui.pseudocode)
+	} else {
+		ui.codeRow.AddItem(ui.pseudocode, 0, 1, false)
+	}
+	ui.pseudocode.shown = !ui.pseudocode.shown
+}
+
+func (pv *PseudocodeView) decompiled() *decompiler.Listing {
+	code := pv.ui.vm.Mem[:pv.ui.vm.ProgramSize]
+	if pv.listing == nil || !bytes.Equal(code, pv.code) {
+		pv.code = append(pv.code[:0], code...)
+		pv.listing = decompiler.Decompile(pv.code, pv.labels)
+	}
+	return pv.listing
+}
+
+func (pv *PseudocodeView) Draw(screen tcell.Screen) {
+	pv.TextView.DrawForSubclass(screen, pv)
+	x, y, width, height := pv.GetInnerRect()
+
+	addr := (pv.ui.code.offset + pv.ui.vm.PC) % MemSize
+	f := pv.decompiled().FunctionAt(addr)
+	if f == nil {
+		pv.SetTitle(" Pseudocode ")
+		tview.Print(screen, "[gray]No code reached from the entry point here", x, y, width, tview.AlignLeft, 0)
+		return
+	}
+	pv.SetTitle(fmt.Sprintf(" Pseudocode · %s() ", f.Name))
+
+	highlighted, current := f.LineAt(addr), f.LineAt(pv.ui.vm.PC)
+	first := highlighted - height/2
+	if first > len(f.Lines)-height {
+		first = len(f.Lines) - height
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	for i := 0; i < height && first+i < len(f.Lines); i++ {
+		k := first + i
+		line := f.Lines[k]
+
+		var color string
+		switch {
+		case k == current && k == highlighted:
+			color = "[green:gray:b]"
+		case k == current:
+			color = "[green::b]"
+		case k == highlighted:
+			color = "[:gray:b]"
+		}
+
+		text := strings.Repeat("  ", line.Depth) + tview.Escape(line.Text)
+		_, printedWidth := tview.Print(screen, color+text, x, y+i, width, tview.AlignLeft, 0)
+
+		if color != "" {
+			_, _, style, _ := screen.GetContent(x, y+i)
+			for printedWidth < width {
+				screen.SetContent(x+printedWidth, y+i, ' ', nil, style)
+				printedWidth++
+			}
+		}
+	}
+}
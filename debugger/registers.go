@@ -4,21 +4,176 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/gdamore/tcell/v2"
+	"github.com/pkg/errors"
 	"github.com/rivo/tview"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// Selectable items of the Registers pane, r0 - r15 come first
+const (
+	regItemPC   = vm.RegCount
+	regItemFlag = vm.RegCount + 1
 )
 
-func NewRegistersView() *tview.TextView {
-	rv := tview.NewTextView()
+type RegistersView struct {
+	*tview.TextView
+	ui *UI
+
+	selected int
+}
+
+func NewRegistersView(ui *UI) *RegistersView {
+	rv := &RegistersView{
+		TextView: tview.NewTextView(),
+		ui:       ui,
+	}
 	rv.SetDynamicColors(true)
 	rv.SetBorderPadding(1, 1, 2, 2)
 	rv.SetBorder(true).SetTitle(" Registers ").SetTitleAlign(tview.AlignLeft)
 	return rv
 }
 
+// Move moves the selection in the two column register grid.
+func (rv *RegistersView) Move(dx, dy int) {
+	switch {
+	case dx != 0 && rv.selected < vm.RegCount:
+		rv.selected ^= 1
+	case dx != 0:
+		rv.selected = regItemPC + regItemFlag - rv.selected
+	case dy < 0 && rv.selected >= vm.RegCount:
+		rv.selected -= 2
+	case dy < 0 && rv.selected >= 2:
+		rv.selected -= 2
+	case dy > 0 && rv.selected < vm.RegCount:
+		rv.selected += 2
+	}
+}
+
+// Edit changes the selected item, Flag is simply toggled.
+func (rv *RegistersView) Edit() {
+	ui := rv.ui
+
+	switch rv.selected {
+	case regItemFlag:
+		ui.vm.Flag = !ui.vm.Flag
+
+	case regItemPC:
+		ui.ShowPrompt("PC", fmt.Sprintf("0x%03x", ui.vm.PC), func(text string) error {
+			pc, err := parseValue(text, 16)
+			if err != nil {
+				return err
+			} else if pc >= MemSize {
+				return errors.Errorf("Address out of range: 0x%x", pc)
+			}
+			ui.vm.PC = uint16(pc)
+			ui.code.offset = 0
+			return nil
+		})
+
+	default:
+		reg := rv.selected
+		title := fmt.Sprintf("r%d (0x1f, 1fh, 31 or 'c')", reg)
+		ui.ShowPrompt(title, fmt.Sprintf("0x%02x", ui.vm.GetReg(reg)), func(text string) error {
+			value, err := parseValue(text, 8)
+			if err != nil {
+				return err
+			}
+			ui.vm.SetReg(reg, byte(value))
+			return nil
+		})
+	}
+}
+
+func (rv *RegistersView) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+	return rv.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+		switch event.Key() {
+		case tcell.KeyUp:
+			rv.Move(0, -1)
+		case tcell.KeyDown:
+			rv.Move(0, 1)
+		case tcell.KeyLeft:
+			rv.Move(-1, 0)
+		case tcell.KeyRight:
+			rv.Move(1, 0)
+		case tcell.KeyEnter:
+			rv.Edit()
+		case tcell.KeyRune:
+			if event.Rune() == ' ' && rv.selected == regItemFlag {
+				rv.Edit()
+			}
+		}
+	})
+}
+
+func (rv *RegistersView) Draw(screen tcell.Screen) {
+	// Selection highlight depends on focus, which may change without a Refresh
+	rv.ui.UpdateRegisters()
+	rv.TextView.Draw(screen)
+}
+
+func (rv *RegistersView) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return rv.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		x, y := event.Position()
+		if !rv.InRect(x, y) {
+			return false, nil
+		}
+
+		switch action {
+		case tview.MouseLeftClick:
+			setFocus(rv)
+			rv.clickAt(x, y)
+		case tview.MouseLeftDoubleClick:
+			rv.clickAt(x, y)
+			rv.Edit()
+		default:
+			consumed = false
+			return
+		}
+
+		consumed = true
+		return
+	})
+}
+
+func (rv *RegistersView) clickAt(x, y int) {
+	innerX, innerY, width, _ := rv.GetInnerRect()
+	col, row := x-innerX, y-innerY
+
+	switch {
+	case row >= 1 && row <= vm.RegCount/2:
+		rv.selected = (row-1)*2 + col*2/width
+	case row == vm.RegCount/2+2:
+		if col < 10 {
+			rv.selected = regItemPC
+		} else {
+			rv.selected = regItemFlag
+		}
+	}
+}
+
 func (ui *UI) UpdateRegisters() {
 	var text strings.Builder
 
 	const regColor = "[green:-:b]"
+	const selectedColor = "[black:green:b]"
+
+	itemColor := func(item int) string {
+		if item == ui.registers.selected && ui.registers.HasFocus() {
+			return selectedColor
+		}
+		return regColor
+	}
+
+	// Values depending on the input are underlined
+	taintTag := func(taint vm.Taint) string {
+		if len(taint) > 0 {
+			return "[::u]"
+		}
+		return ""
+	}
+
 	fmtRegPair := func(i, j int) string {
 		ri, rj := ui.vm.GetReg(i), ui.vm.GetReg(j)
 		var riChar, rjChar string
@@ -35,9 +190,9 @@ func (ui *UI) UpdateRegisters() {
 		if j < 10 {
 			padding = " "
 		}
-		return fmt.Sprintf("%s%sr%d[-:-:-]: %02x%2s %s%sr%d[-:-:-]: %02x%2s",
-			padding, regColor, i, ri, riChar,
-			padding, regColor, j, rj, rjChar)
+		return fmt.Sprintf("%s%sr%d[-:-:-]: %s%s%02x%2s[-:-:-] %s%sr%d[-:-:-]: %s%s%02x%2s[-:-:-]",
+			padding, itemColor(i), i, ui.changes.RegTag(i), taintTag(ui.vm.RegTaint(i)), ri, tview.Escape(riChar),
+			padding, itemColor(j), j, ui.changes.RegTag(j), taintTag(ui.vm.RegTaint(j)), rj, tview.Escape(rjChar))
 	}
 
 	loadStoreStr := fmt.Sprintf(" 0x%03x/%d ", ui.vm.GetLoadStoreOffset(),
@@ -78,8 +233,24 @@ func (ui *UI) UpdateRegisters() {
 	text.WriteByte('\n')
 
 	text.WriteString(fmtRegPair(14, 15))
-	text.WriteString(fmt.Sprintf("\n\n %sPC[-:-:-]: %03x %sFlag[-:-:-]: %v\n",
-		regColor, ui.vm.PC, regColor, ui.vm.Flag))
+	text.WriteString(fmt.Sprintf("\n\n %sPC[-:-:-]: %03x %sFlag[-:-:-]: %s%s%v[-:-:-]\n",
+		itemColor(regItemPC), ui.vm.PC, itemColor(regItemFlag), ui.changes.FlagTag(),
+		taintTag(ui.vm.FlagTaint()), ui.vm.Flag))
+
+	// The title tells what the selected item depends on
+	var name string
+	var taint vm.Taint
+	switch selected := ui.registers.selected; {
+	case selected < vm.RegCount:
+		name, taint = fmt.Sprintf("r%d", selected), ui.vm.RegTaint(selected)
+	case selected == regItemFlag:
+		name, taint = "Flag", ui.vm.FlagTaint()
+	}
+	if len(taint) > 0 {
+		ui.registers.SetTitle(fmt.Sprintf(" Registers · %s ← %s ", name, taint))
+	} else {
+		ui.registers.SetTitle(" Registers ")
+	}
 
 	ui.registers.SetText(text.String())
 }
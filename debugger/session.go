@@ -0,0 +1,177 @@
+package debugger
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+)
+
+// Session is the debugger state kept between runs of the same program.
+type Session struct {
+	Input             string     `json:"input,omitempty"`
+	Interactive       bool       `json:"interactive,omitempty"`
+	BreakOnSelfModify bool       `json:"breakOnSelfModify,omitempty"`
+	CheckMemory       bool       `json:"checkMemory,omitempty"`
+	BreakOnWarning    bool       `json:"breakOnWarning,omitempty"`
+	TrackTaint        bool       `json:"trackTaint,omitempty"`
+	Breakpoints       []Location `json:"breakpoints,omitempty"`
+	Watchpoints       []Location `json:"watchpoints,omitempty"`
+	CodeOffset        uint16     `json:"codeOffset"`
+	MemoryOffset      uint16     `json:"memoryOffset"`
+	MemoryCursor      uint16     `json:"memoryCursor"`
+	OutputASCII       bool       `json:"outputAscii"`
+	Pseudocode        bool       `json:"pseudocode,omitempty"`
+}
+
+// Location is an address, along with the closest label and the source
+// line (when assembled from source) so it can be found after recompiling.
+type Location struct {
+	Addr   uint16 `json:"addr"`
+	Label  string `json:"label,omitempty"`
+	Offset uint16 `json:"offset,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+// LoadSession reads a session file, a missing file is an empty session.
+func LoadSession(path string) (*Session, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Session{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, errors.Wrapf(err, "Bad session file %s", path)
+	}
+	return &s, nil
+}
+
+func (s *Session) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func locate(addr uint16, listing *assembler.Listing) Location {
+	loc := Location{Addr: addr}
+
+	var labels []string
+	for label := range listing.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		labelAddr := listing.Labels[label]
+		if labelAddr <= addr && (loc.Label == "" || labelAddr > addr-loc.Offset) {
+			loc.Label = label
+			loc.Offset = addr - labelAddr
+		}
+	}
+
+	if line := listing.LineAt(addr); line != nil && line.Addr == addr {
+		loc.Line = line.Number
+		loc.Source = strings.TrimSpace(line.Text)
+	}
+	return loc
+}
+
+// resolve finds the location in a (possibly changed) listing: the line
+// with the same source closest to the original line number, else the
+// label, else the plain address.
+func (loc Location) resolve(listing *assembler.Listing) uint16 {
+	if loc.Line != 0 {
+		var found *assembler.Line
+		for _, line := range listing.Lines {
+			if len(line.Bytecode) == 0 || strings.TrimSpace(line.Text) != loc.Source {
+				continue
+			}
+			if found == nil || abs(line.Number-loc.Line) < abs(found.Number-loc.Line) {
+				found = line
+			}
+		}
+		if found != nil {
+			return found.Addr
+		}
+	}
+
+	if addr, ok := listing.Labels[loc.Label]; ok && loc.Label != "" {
+		return addr + loc.Offset
+	}
+	return loc.Addr
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Session returns the current debugger state, listing (empty for
+// binaries) is used to describe break and watchpoints.
+func (ui *UI) Session(listing *assembler.Listing) *Session {
+	s := &Session{
+		Input:             hex.EncodeToString(ui.vm.Input),
+		Interactive:       ui.vm.InteractiveInput,
+		BreakOnSelfModify: ui.vm.BreakOnSelfModify,
+		CheckMemory:       ui.vm.CheckMemory,
+		BreakOnWarning:    ui.vm.BreakOnWarning,
+		TrackTaint:        ui.vm.TrackTaint,
+		CodeOffset:        ui.code.offset,
+		MemoryOffset:      ui.memory.offset,
+		MemoryCursor:      ui.memory.cursor,
+		OutputASCII:       ui.output.ascii,
+		Pseudocode:        ui.pseudocode.shown,
+	}
+
+	for addr := uint16(0); addr < MemSize; addr++ {
+		if addr%2 == 0 && ui.vm.BreakpointSet(addr) {
+			s.Breakpoints = append(s.Breakpoints, locate(addr, listing))
+		}
+		if ui.vm.WatchPoints[addr] {
+			s.Watchpoints = append(s.Watchpoints, locate(addr, listing))
+		}
+	}
+	return s
+}
+
+// RestoreSession applies a saved session, except for the input which is
+// passed to NewUI.
+func (ui *UI) RestoreSession(s *Session, listing *assembler.Listing) {
+	for _, loc := range s.Breakpoints {
+		if addr := loc.resolve(listing); !ui.vm.BreakpointSet(addr) {
+			ui.vm.ToggleBreakpoint(addr)
+		}
+	}
+	for _, loc := range s.Watchpoints {
+		ui.vm.WatchPoints[loc.resolve(listing)%MemSize] = true
+	}
+
+	ui.vm.InteractiveInput = ui.vm.InteractiveInput || s.Interactive
+	ui.vm.BreakOnSelfModify = s.BreakOnSelfModify
+	ui.vm.CheckMemory = ui.vm.CheckMemory || s.CheckMemory
+	ui.vm.BreakOnWarning = ui.vm.BreakOnWarning || s.BreakOnWarning
+	ui.vm.TrackTaint = s.TrackTaint
+	ui.code.offset = s.CodeOffset % MemSize
+	ui.memory.offset = s.MemoryOffset % MemSize
+	ui.memory.cursor = s.MemoryCursor % MemSize
+	ui.output.ascii = s.OutputASCII
+	ui.output.UpdateTitle()
+	if s.Pseudocode != (ui.pseudocode.shown) {
+		ui.TogglePseudocode()
+	}
+	ui.Refresh()
+}
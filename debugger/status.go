@@ -14,6 +14,7 @@ type StatusBar struct {
 
 	ui        *UI
 	errorText string
+	infoText  string
 }
 
 func NewStatusBar(ui *UI) *StatusBar {
@@ -31,6 +32,14 @@ func (sb *StatusBar) ClearErrorText() {
 	sb.SetErrorText("")
 }
 
+func (sb *StatusBar) SetInfoText(str string) {
+	sb.infoText = str
+}
+
+func (sb *StatusBar) ClearInfoText() {
+	sb.SetInfoText("")
+}
+
 func (sb *StatusBar) Draw(screen tcell.Screen) {
 	sb.Box.DrawForSubclass(screen, sb)
 	x, y, width, _ := sb.GetInnerRect()
@@ -54,6 +63,8 @@ func (sb *StatusBar) Draw(screen tcell.Screen) {
 
 	if sb.errorText != "" {
 		tview.Print(screen, fmt.Sprintf("[red]%s[-:-:-]", sb.errorText), x, y, width, tview.AlignLeft, 0)
+	} else if sb.infoText != "" {
+		tview.Print(screen, sb.infoText, x, y, width, tview.AlignLeft, 0)
 	} else {
 		tview.Print(screen, "Press [green:-:b]F1[-:-:-] for help", x, y, width, tview.AlignLeft, 0)
 	}
@@ -9,17 +9,21 @@ import (
 type UI struct {
 	app *tview.Application
 
-	code      *CodeView
-	input     *tview.TextView
-	memory    *MemoryView
-	modal     *tview.Modal
-	output    *OutputView
-	pages     *tview.Pages
-	registers *tview.TextView
-	status    *StatusBar
+	code       *CodeView
+	codeRow    *tview.Flex // Code and, when shown, Pseudocode
+	console    *ConsoleView
+	input      *tview.TextView
+	memory     *MemoryView
+	modal      *tview.Modal
+	output     *OutputView
+	pages      *tview.Pages
+	pseudocode *PseudocodeView
+	registers  *RegistersView
+	status     *StatusBar
+
+	changes *ChangeTracker
 
 	program    []byte
-	inputBytes []byte
 	cycleLimit int
 
 	vm *vm.VM
@@ -29,19 +33,21 @@ func (ui *UI) MainLoop() error {
 	return ui.app.Run()
 }
 
-func NewUI(program, inputBytes []byte, cycleLimit int) (*UI, error) {
+// NewUI creates the debugger for program, labels (if known) are used by
+// the console.
+func NewUI(program, inputBytes []byte, cycleLimit int, labels map[string]uint16) (*UI, error) {
 	ui := &UI{
 		app: tview.NewApplication(),
 
-		input:     NewInputView(),
-		modal:     tview.NewModal(),
-		output:    NewOutputView(),
-		pages:     tview.NewPages(),
-		registers: NewRegistersView(),
+		input:  NewInputView(),
+		modal:  tview.NewModal(),
+		output: NewOutputView(),
+		pages:  tview.NewPages(),
 
 		program:    program,
-		inputBytes: inputBytes,
 		cycleLimit: cycleLimit,
+
+		changes: NewChangeTracker(true),
 	}
 
 	vm, err := vm.NewVM(program, inputBytes, cycleLimit)
@@ -52,19 +58,25 @@ func NewUI(program, inputBytes []byte, cycleLimit int) (*UI, error) {
 
 	ui.code = NewCodeView(ui)
 	ui.memory = NewMemoryView(ui)
+	ui.registers = NewRegistersView(ui)
 	ui.status = NewStatusBar(ui)
+	ui.console = NewConsoleView(ui, labels)
+	ui.pseudocode = NewPseudocodeView(ui, labels)
+	ui.codeRow = tview.NewFlex().SetDirection(tview.FlexColumn).
+		AddItem(ui.code, 0, 1, false)
 
 	mainView := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(ui.input, 3, 0, false).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexColumn).
 			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
-				AddItem(ui.code, 0, 3, false).
+				AddItem(ui.codeRow, 0, 3, false).
 				AddItem(ui.memory, 0, 2, true), 0, 1, false).
 			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
 				AddItem(ui.registers, 15, 0, false).
 				AddItem(ui.output, 0, 1, false),
 				27, 0, false),
 			0, 1, false).
+		AddItem(ui.console, 10, 0, false).
 		AddItem(ui.status, 1, 0, false)
 
 	mainView.SetInputCapture(ui.HandleKeyboard)
@@ -79,34 +91,138 @@ func NewUI(program, inputBytes []byte, cycleLimit int) (*UI, error) {
 	return ui, nil
 }
 
+// ExecScript runs console commands, e.g. from debug --script.
+func (ui *UI) ExecScript(script string) {
+	ui.console.ExecScript(script)
+	ui.Refresh()
+}
+
 func (ui *UI) ToggleBreakpoint() {
 	ui.vm.ToggleBreakpoint(ui.code.lastHighlightedPC)
 }
 
+// VM, Step, Run and Restart implement console.Target, errors are
+// returned instead of being shown.
+
+func (ui *UI) VM() *vm.VM {
+	return ui.vm
+}
+
+func (ui *UI) Step() error {
+	ui.changes.Before(ui.vm)
+	err := ui.vm.Step()
+	ui.status.SetInfoText(ui.stopInfo() + ui.changes.After(ui.vm))
+
+	if err == nil {
+		ui.code.offset = 0
+	}
+	return err
+}
+
+func (ui *UI) Run() error {
+	ui.changes.Before(ui.vm)
+	err := ui.vm.Run()
+	ui.status.SetInfoText(ui.stopInfo() + ui.changes.After(ui.vm))
+
+	if err == nil {
+		ui.code.offset = 0
+	}
+	return err
+}
+
+// stopInfo describes memory check warnings and self-modifying code.
+func (ui *UI) stopInfo() string {
+	if w, found := ui.vm.Warning(); found {
+		return "Warning: " + w.String() + " | "
+	} else if sm, found := ui.vm.SelfModified(); found && !sm.Executing {
+		return "Self-modifying code: " + sm.String() + " | "
+	} else if ui.vm.ModifiedCodeNext() {
+		return "Next instruction was modified at runtime | "
+	}
+	return ""
+}
+
+func (ui *UI) ToggleBreakOnSelfModify() {
+	ui.vm.BreakOnSelfModify = !ui.vm.BreakOnSelfModify
+	if ui.vm.BreakOnSelfModify {
+		ui.status.SetInfoText("Breaking on self-modifying code")
+	} else {
+		ui.status.SetInfoText("Not breaking on self-modifying code")
+	}
+}
+
+// ToggleTaint switches taint tracking, tainted values are underlined.
+func (ui *UI) ToggleTaint() {
+	ui.vm.TrackTaint = !ui.vm.TrackTaint
+	switch {
+	case ui.vm.TrackTaint && ui.vm.InputIndex > 0:
+		ui.status.SetInfoText("Tracking taint (restart to include the input already read)")
+	case ui.vm.TrackTaint:
+		ui.status.SetInfoText("Tracking taint: values depending on the input are underlined")
+	default:
+		ui.status.SetInfoText("Not tracking taint")
+	}
+	ui.Refresh()
+}
+
+// CycleMemoryChecks switches between no memory checks, warnings and
+// breaking on warnings.
+func (ui *UI) CycleMemoryChecks() {
+	switch {
+	case ui.vm.BreakOnWarning:
+		ui.vm.CheckMemory, ui.vm.BreakOnWarning = false, false
+		ui.status.SetInfoText("Memory checks off")
+	case ui.vm.CheckMemory:
+		ui.vm.BreakOnWarning = true
+		ui.status.SetInfoText("Memory checks on, breaking on warnings")
+	default:
+		ui.vm.CheckMemory = true
+		ui.status.SetInfoText("Memory checks on (uninitialized memory is dimmed)")
+	}
+}
+
+// Restart reloads the program, the (possibly edited) input and the VM
+// settings are kept.
+func (ui *UI) Restart() error {
+	newVM, err := vm.NewVM(ui.program, append([]byte{}, ui.vm.Input...), ui.cycleLimit)
+	if err != nil {
+		return err
+	}
+
+	newVM.KeepSettings(ui.vm)
+	ui.SetVM(newVM)
+	return nil
+}
+
+// SetVM replaces the VM being debugged, e.g. with a loaded snapshot.
+func (ui *UI) SetVM(m *vm.VM) {
+	ui.vm = m
+	ui.changes = NewChangeTracker(ui.changes.fade)
+	ui.code.offset = 0
+	ui.Refresh()
+}
+
 func (ui *UI) StepVM() {
 	previousState := ui.vm.State
-	if err := ui.vm.Step(); err == nil {
-		ui.code.offset = 0
-	} else if previousState != vm.Error {
+	if err := ui.Step(); err != nil && previousState != vm.Error {
 		ui.ShowError()
+	} else if ui.vm.WaitingForInput() {
+		ui.PromptForInput(ui.StepVM)
 	}
 }
 
 func (ui *UI) RunVM() {
 	previousState := ui.vm.State
-	if err := ui.vm.Run(); err == nil {
-		ui.code.offset = 0
-	} else if previousState != vm.Error {
+	if err := ui.Run(); err != nil && previousState != vm.Error {
 		ui.ShowError()
+	} else if ui.vm.WaitingForInput() {
+		ui.PromptForInput(ui.RunVM)
 	}
 }
 
 func (ui *UI) RestartVM() {
-	if newVM, err := vm.NewVM(ui.program, ui.inputBytes, ui.cycleLimit); err != nil {
+	if err := ui.Restart(); err != nil {
 		panic(err)
-	} else {
-		ui.vm = newVM
-		ui.Refresh()
 	}
 }
 
@@ -0,0 +1,230 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// cond is a branch condition, a comparison or just the flag (op "").
+type cond struct {
+	a, op, b string
+}
+
+var (
+	cmpOps  = [...]string{"==", "!=", "<", "<=", ">", ">="}
+	negated = map[string]string{"==": "!=", "!=": "==", "<": ">=", "<=": ">", ">": "<=", ">=": "<"}
+	aluOps  = [...]string{"&=", "|=", "^=", "<<=", ">>=", "+=", "-="}
+)
+
+func (c cond) String() string {
+	if c.op == "" {
+		return c.a
+	}
+	return c.a + " " + c.op + " " + c.b
+}
+
+func (c cond) not() cond {
+	if c.op != "" {
+		c.op = negated[c.op]
+	} else if strings.HasPrefix(c.a, "!") {
+		c.a = c.a[1:]
+	} else {
+		c.a = "!" + c.a
+	}
+	return c
+}
+
+// stmt is a statement and the instructions it stands for, NOPEs have no
+// text.
+type stmt struct {
+	text  string
+	addrs []uint16
+}
+
+// body is a block translated to statements, its final HOPP or BHOPP is
+// left to the structurer.
+type body struct {
+	stmts     []stmt
+	cond      cond     // of the BHOPP
+	condAddrs []uint16 // the BHOPP and what was folded into cond
+}
+
+func literal(v byte) string {
+	switch {
+	case v < ' ':
+		return fmt.Sprint(v)
+	case v == '\'' || v == '\\':
+		return fmt.Sprintf("'\\%c'", v)
+	case v > ' ' && v <= '~':
+		return fmt.Sprintf("'%c'", v)
+	}
+	return fmt.Sprintf("0x%02x", v)
+}
+
+func (d *decompiler) body(f *function, b *block) *body {
+	n := len(b.instrs)
+	done := make([]bool, n)
+	bd := &body{}
+
+	last := b.last()
+	end := n
+	if last.Class == vm.OpClassJmp || last.Class == vm.OpClassCondJmp {
+		end = n - 1
+	}
+
+	// Fold the comparison deciding the branch into it
+	if last.Class == vm.OpClassCondJmp {
+		bd.cond = cond{a: "flag"}
+		bd.condAddrs = []uint16{last.addr}
+		for k := n - 2; k >= 0; k-- {
+			i := b.instrs[k]
+			if i.Class == vm.OpClassCmp && i.valid() {
+				if d.liveAfter(f, b, n-1)&flagBit == 0 && !changed(b, k+1, n-1, reg(i.Arg1)|reg(i.Arg2)) {
+					var addrs []uint16
+					bd.cond = cond{d.operand(f, b, k, i.Arg1, i.Arg2, done, &addrs), cmpOps[i.Op], d.operand(f, b, k, i.Arg2, i.Arg1, done, &addrs)}
+					bd.condAddrs = append(append(addrs, i.addr), last.addr)
+					done[k] = true
+				}
+				break
+			}
+			if _, def := useDef(i); def&flagBit != 0 || i.Class == vm.OpClassCall {
+				break
+			}
+		}
+	}
+
+	for k := 0; k < end; k++ {
+		if done[k] {
+			continue
+		}
+		i := b.instrs[k]
+		if i.Class == vm.OpClassFinn {
+			if text, j := d.memAccess(f, b, k, end); j > k {
+				addrs := []uint16{}
+				for ; k <= j; k++ {
+					addrs = append(addrs, b.instrs[k].addr)
+				}
+				k--
+				bd.stmts = append(bd.stmts, stmt{text, addrs})
+				continue
+			}
+		}
+		bd.stmts = append(bd.stmts, stmt{d.statement(i), []uint16{i.addr}})
+	}
+	return bd
+}
+
+// empty reports whether nothing but the final jump shows.
+func (bd *body) empty() bool {
+	for _, st := range bd.stmts {
+		if st.text != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// changed reports whether instructions from..to-1 of b write regs.
+func changed(b *block, from, to int, regs uint32) bool {
+	for j := from; j < to; j++ {
+		if _, def := useDef(b.instrs[j]); def&regs != 0 || b.instrs[j].Class == vm.OpClassCall {
+			return true
+		}
+	}
+	return false
+}
+
+// operand is how r reads in the comparison at k: the constant from a SETT
+// just for it, or the register.
+func (d *decompiler) operand(f *function, b *block, k, r, other int, done []bool, addrs *[]uint16) string {
+	name := fmt.Sprintf("r%d", r)
+	if r == other || d.liveAfter(f, b, k)&reg(r) != 0 {
+		return name
+	}
+	for s := k - 1; s >= 0; s-- {
+		i := b.instrs[s]
+		use, def := useDef(i)
+		if def&reg(r) != 0 {
+			if i.Class != vm.OpClassMovImm || done[s] {
+				return name
+			}
+			done[s] = true
+			*addrs = append(*addrs, i.addr)
+			return literal(i.Val)
+		}
+		if use&reg(r) != 0 {
+			return name
+		}
+	}
+	return name
+}
+
+// memAccess folds FINN at k, PLUSS r0s and the LAST or LAGR after them
+// into one statement when r0 and r1 aren't needed afterwards, returning
+// the index of the LAST or LAGR (or k when it can't).
+func (d *decompiler) memAccess(f *function, b *block, k, end int) (string, int) {
+	addr := d.address(b.instrs[k].Addr)
+	j := k + 1
+	for ; j < end; j++ {
+		i := b.instrs[j]
+		if i.Class != vm.OpClassALU || i.Op != 5 || i.Arg1 != 0 || i.Arg2 <= 1 {
+			break
+		}
+		addr += fmt.Sprintf(" + r%d", i.Arg2)
+	}
+	if j == end {
+		return "", k
+	}
+	i := b.instrs[j]
+	if i.Class != vm.OpClassLoadStore || !i.valid() || i.Arg1 <= 1 || d.liveAfter(f, b, j)&(reg(0)|reg(1)) != 0 {
+		return "", k
+	}
+	if i.Op == 0 {
+		return fmt.Sprintf("r%d = mem[%s]", i.Arg1, addr), j
+	}
+	return fmt.Sprintf("mem[%s] = r%d", addr, i.Arg1), j
+}
+
+// statement is the pseudocode for a single instruction, "" for NOPE.
+func (d *decompiler) statement(i instr) string {
+	if !i.valid() {
+		return "// " + i.String()
+	}
+	switch i.Class {
+	case vm.OpClassHalt:
+		return "stop"
+	case vm.OpClassMovImm:
+		return fmt.Sprintf("r%d = %s", i.Op, literal(i.Val))
+	case vm.OpClassMovReg:
+		return fmt.Sprintf("r%d = r%d", i.Op, i.Arg1)
+	case vm.OpClassFinn:
+		if name, ok := d.names[i.Addr]; ok {
+			return "r1:r0 = &" + name
+		}
+		return fmt.Sprintf("r1:r0 = 0x%03x", i.Addr)
+	case vm.OpClassLoadStore:
+		if i.Op == 0 {
+			return fmt.Sprintf("r%d = mem[r1:r0]", i.Arg1)
+		}
+		return fmt.Sprintf("mem[r1:r0] = r%d", i.Arg1)
+	case vm.OpClassALU:
+		if i.Arg1 == i.Arg2 && (i.Op == 2 || i.Op == 6) {
+			return fmt.Sprintf("r%d = 0", i.Arg1)
+		}
+		return fmt.Sprintf("r%d %s r%d", i.Arg1, aluOps[i.Op], i.Arg2)
+	case vm.OpClassIO:
+		if i.Op == 0 {
+			return fmt.Sprintf("r%d = read()", i.Arg1)
+		}
+		return fmt.Sprintf("write(r%d)", i.Arg1)
+	case vm.OpClassCmp:
+		return fmt.Sprintf("flag = r%d %s r%d", i.Arg1, cmpOps[i.Op], i.Arg2)
+	case vm.OpClassCall:
+		return d.funcs[int(i.Addr)] + "()"
+	case vm.OpClassRet:
+		return "return"
+	}
+	return ""
+}
@@ -0,0 +1,272 @@
+package decompiler
+
+import (
+	"sort"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// instr is an instruction with its address.
+type instr struct {
+	addr uint16
+	*vm.Instruction
+}
+
+func (i instr) valid() bool {
+	switch i.Class {
+	case vm.OpClassLoadStore, vm.OpClassIO:
+		return i.Op <= 1
+	case vm.OpClassALU:
+		return i.Op <= 6
+	case vm.OpClassCmp:
+		return i.Op <= 5
+	}
+	return i.Class <= vm.OpClassNop
+}
+
+// ends reports whether i is the last instruction of a block.
+func (i instr) ends() bool {
+	switch i.Class {
+	case vm.OpClassHalt, vm.OpClassJmp, vm.OpClassCondJmp, vm.OpClassRet:
+		return true
+	}
+	return !i.valid()
+}
+
+// block is a basic block, its instructions run from start to end.
+type block struct {
+	start, end int
+	instrs     []instr
+	succs      []int // start addresses
+
+}
+
+func (b *block) last() instr {
+	return b.instrs[len(b.instrs)-1]
+}
+
+// cfg is the control flow graph recovered from the entry points (0 and
+// TUR targets) by following jumps, branches and calls.
+type cfg struct {
+	mem     [vm.MemSize]byte
+	size    int // of the program
+	code    [vm.MemSize]bool
+	blocks  map[int]*block
+	entries []int
+}
+
+func (g *cfg) decode(addr int) instr {
+	word := uint16(g.mem[addr]) | uint16(g.mem[(addr+1)%vm.MemSize])<<8
+	return instr{uint16(addr), vm.ParseInstruction(word)}
+}
+
+func buildCFG(code []byte) *cfg {
+	g := &cfg{size: len(code), blocks: map[int]*block{}}
+	copy(g.mem[:], code)
+
+	// Find the instructions and where blocks start
+	leaders := map[int]bool{0: true}
+	entries := map[int]bool{0: true}
+	seen := map[int]bool{}
+	work := []int{0}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for pc+1 < vm.MemSize && !seen[pc] {
+			seen[pc] = true
+			i := g.decode(pc)
+			g.code[pc], g.code[pc+1] = true, true
+
+			switch i.Class {
+			case vm.OpClassJmp, vm.OpClassCondJmp, vm.OpClassCall:
+				leaders[int(i.Addr)] = true
+				work = append(work, int(i.Addr))
+				if i.Class == vm.OpClassCall {
+					entries[int(i.Addr)] = true
+				}
+			}
+			if i.ends() {
+				leaders[pc+2] = true
+				if i.Class == vm.OpClassCondJmp {
+					work = append(work, pc+2)
+				}
+				break
+			}
+			pc += 2
+		}
+	}
+
+	for pc := range seen {
+		if !leaders[pc] && seen[pc-2] && !g.decode(pc-2).ends() {
+			continue
+		}
+		b := &block{start: pc}
+		for addr := pc; ; addr += 2 {
+			i := g.decode(addr)
+			b.instrs = append(b.instrs, i)
+			b.end = addr + 2
+			if i.ends() || leaders[b.end] || !seen[b.end] {
+				break
+			}
+		}
+		g.blocks[pc] = b
+	}
+
+	for _, b := range g.blocks {
+		last := b.last()
+		switch {
+		case last.Class == vm.OpClassJmp:
+			b.succs = []int{int(last.Addr)}
+		case last.Class == vm.OpClassCondJmp:
+			b.succs = []int{b.end, int(last.Addr)}
+		case !last.ends() && g.blocks[b.end] != nil:
+			b.succs = []int{b.end}
+		}
+	}
+
+	for entry := range entries {
+		g.entries = append(g.entries, entry)
+	}
+	sort.Ints(g.entries)
+	return g
+}
+
+// function is the blocks reachable from entry without following calls,
+// sorted by address, with what is live after each.
+type function struct {
+	entry   int
+	blocks  []*block
+	byStart map[int]*block
+	liveOut map[*block]uint32
+}
+
+func (g *cfg) function(entry int) *function {
+	f := &function{entry: entry, byStart: map[int]*block{}, liveOut: map[*block]uint32{}}
+	work := []int{entry}
+	for len(work) > 0 {
+		start := work[len(work)-1]
+		work = work[:len(work)-1]
+		if f.byStart[start] != nil || g.blocks[start] == nil {
+			continue
+		}
+		f.byStart[start] = g.blocks[start]
+		f.blocks = append(f.blocks, g.blocks[start])
+		work = append(work, g.blocks[start].succs...)
+	}
+	sort.Slice(f.blocks, func(i, j int) bool { return f.blocks[i].start < f.blocks[j].start })
+	return f
+}
+
+// Liveness bits: r0 - r15, then the flag
+const (
+	flagBit = 1 << vm.RegCount
+	allBits = flagBit<<1 - 1
+)
+
+func reg(r int) uint32 {
+	return 1 << uint(r)
+}
+
+// useDef is what i reads and writes. Calls are taken to read everything,
+// see liveness for what they really read.
+func useDef(i instr) (use, def uint32) {
+	switch i.Class {
+	case vm.OpClassMovImm:
+		return 0, reg(i.Op)
+	case vm.OpClassMovReg:
+		return reg(i.Arg1), reg(i.Op)
+	case vm.OpClassFinn:
+		return 0, reg(0) | reg(1)
+	case vm.OpClassLoadStore:
+		if i.Op == 0 {
+			return reg(0) | reg(1), reg(i.Arg1)
+		}
+		return reg(0) | reg(1) | reg(i.Arg1), 0
+	case vm.OpClassALU:
+		if i.Arg1 == i.Arg2 && (i.Op == 2 || i.Op == 6) {
+			return 0, reg(i.Arg1)
+		}
+		return reg(i.Arg1) | reg(i.Arg2), reg(i.Arg1)
+	case vm.OpClassIO:
+		if i.Op == 0 {
+			return 0, reg(i.Arg1)
+		}
+		return reg(i.Arg1), 0
+	case vm.OpClassCmp:
+		return reg(i.Arg1) | reg(i.Arg2), flagBit
+	case vm.OpClassCondJmp:
+		return flagBit, 0
+	case vm.OpClassCall:
+		return allBits, 0
+	}
+	return 0, 0
+}
+
+// liveness sets liveOut for all functions. A call reads what the callee
+// reads before writing it and a RETUR what the callers read after their
+// TURs, found together by iterating until nothing changes.
+func (d *decompiler) liveness(funcs []*function) {
+	for changed := true; changed; {
+		changed = false
+		for _, f := range funcs {
+			if in := d.live(f); in != d.uses[f.entry] {
+				d.uses[f.entry] = in
+				changed = true
+			}
+			for _, b := range f.blocks {
+				for k, i := range b.instrs {
+					if i.Class != vm.OpClassCall {
+						continue
+					}
+					callee := int(i.Addr)
+					if after := d.liveAfter(f, b, k) | d.returns[callee]; after != d.returns[callee] {
+						d.returns[callee] = after
+						changed = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// live sets liveOut for the blocks of f, returning what is live at the
+// entry.
+func (d *decompiler) live(f *function) uint32 {
+	liveIn := map[*block]uint32{}
+	for changed := true; changed; {
+		changed = false
+		for k := len(f.blocks) - 1; k >= 0; k-- {
+			b := f.blocks[k]
+			var out uint32
+			if b.last().Class == vm.OpClassRet {
+				out = d.returns[f.entry]
+			}
+			for _, succ := range b.succs {
+				out |= liveIn[f.byStart[succ]]
+			}
+			f.liveOut[b] = out
+
+			if in := d.liveAfter(f, b, -1); in != liveIn[b] {
+				liveIn[b] = in
+				changed = true
+			}
+		}
+	}
+	return liveIn[f.byStart[f.entry]]
+}
+
+// liveAfter is what is live after instruction k of b (before the block
+// for -1).
+func (d *decompiler) liveAfter(f *function, b *block, k int) uint32 {
+	live := f.liveOut[b]
+	for j := len(b.instrs) - 1; j > k; j-- {
+		i := b.instrs[j]
+		use, def := useDef(i)
+		if i.Class == vm.OpClassCall {
+			use = d.uses[int(i.Addr)]
+		}
+		live = live&^def | use
+	}
+	return live
+}
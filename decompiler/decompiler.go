@@ -0,0 +1,262 @@
+// Package decompiler turns SLEDE8 bytecode into structured pseudocode: the
+// control flow graph is recovered from the entry point and the TUR
+// targets, if/else and loops are read off the branch layout, and
+// comparisons, FINN + LAST/LAGR and single use constants are folded into
+// the expressions using them.
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// Line of pseudocode, Addr is the first instruction it stands for.
+type Line struct {
+	Addr  uint16
+	Depth int
+	Text  string
+
+	addrs []uint16 // of the instructions
+}
+
+// Function is a subroutine (or the main program at 0).
+type Function struct {
+	Name  string
+	Entry uint16
+	Lines []Line
+
+	lineOf map[uint16]int
+}
+
+// LineAt is the index of the line executing the instruction at addr, -1
+// when it isn't part of f.
+func (f *Function) LineAt(addr uint16) int {
+	if line, ok := f.lineOf[addr]; ok {
+		return line
+	}
+	return -1
+}
+
+// Data is a run of program bytes never reached as code.
+type Data struct {
+	Addr  uint16
+	Label string
+	Bytes []byte
+}
+
+// Listing is a decompiled program.
+type Listing struct {
+	Functions []*Function
+	Data      []Data
+}
+
+// Decompile decompiles code, the program bytes without the .SLEDE8 header.
+// labels (from the assembler listing, may be nil) name functions, jump
+// targets and FINN addresses.
+func Decompile(code []byte, labels map[string]uint16) *Listing {
+	g := buildCFG(code)
+
+	names := map[uint16]string{}
+	for label, addr := range labels {
+		if name, ok := names[addr]; !ok || label < name {
+			names[addr] = label
+		}
+	}
+
+	d := &decompiler{
+		cfg:     g,
+		names:   names,
+		funcs:   map[int]string{},
+		uses:    map[int]uint32{},
+		returns: map[int]uint32{},
+	}
+	for _, entry := range g.entries {
+		name, ok := names[uint16(entry)]
+		switch {
+		case ok:
+		case entry == 0:
+			name = "main"
+		default:
+			name = fmt.Sprintf("sub_%03x", entry)
+		}
+		d.funcs[entry] = name
+	}
+
+	var funcs []*function
+	for _, entry := range g.entries {
+		funcs = append(funcs, g.function(entry))
+	}
+	d.liveness(funcs)
+
+	listing := &Listing{}
+	for _, f := range funcs {
+		if len(f.blocks) > 0 { // not for a TUR to the last byte
+			listing.Functions = append(listing.Functions, d.function(f))
+		}
+	}
+
+	for addr := 0; addr < g.size; {
+		if g.code[addr] {
+			addr++
+			continue
+		}
+		data := Data{Addr: uint16(addr), Label: names[uint16(addr)]}
+		for ; addr < g.size && !g.code[addr]; addr++ {
+			if _, ok := names[uint16(addr)]; ok && addr > int(data.Addr) {
+				break
+			}
+			data.Bytes = append(data.Bytes, g.mem[addr])
+		}
+		listing.Data = append(listing.Data, data)
+	}
+	return listing
+}
+
+// FunctionAt is the function containing the instruction at addr, nil if
+// it isn't code.
+func (l *Listing) FunctionAt(addr uint16) *Function {
+	for _, f := range l.Functions {
+		if f.LineAt(addr) >= 0 {
+			return f
+		}
+	}
+	return nil
+}
+
+// String formats the listing, indenting with four spaces.
+func (l *Listing) String() string {
+	return l.format(false)
+}
+
+// WithAddresses formats the listing with the address of each line.
+func (l *Listing) WithAddresses() string {
+	return l.format(true)
+}
+
+func (l *Listing) format(addresses bool) string {
+	var sb strings.Builder
+	for k, f := range l.Functions {
+		if k > 0 {
+			sb.WriteString("\n")
+		}
+		for _, line := range f.Lines {
+			if addresses {
+				fmt.Fprintf(&sb, "%03x  ", line.Addr)
+			}
+			fmt.Fprintf(&sb, "%s%s\n", strings.Repeat("    ", line.Depth), line.Text)
+		}
+	}
+	if len(l.Data) > 0 {
+		sb.WriteString("\n")
+	}
+	for _, data := range l.Data {
+		if addresses {
+			fmt.Fprintf(&sb, "%03x  ", data.Addr)
+		}
+		sb.WriteString(data.String() + "\n")
+	}
+	return sb.String()
+}
+
+func (d Data) String() string {
+	name := d.Label
+	if name == "" {
+		name = fmt.Sprintf("data_%03x", d.Addr)
+	}
+
+	printable := 0
+	for _, b := range d.Bytes {
+		if b >= ' ' && b <= '~' || b == '\n' {
+			printable++
+		}
+	}
+	if printable*4 >= len(d.Bytes)*3 || printable > 0 && d.Bytes[len(d.Bytes)-1] == 0 && printable == len(d.Bytes)-1 {
+		return fmt.Sprintf("data %s = %q", name, d.Bytes)
+	}
+	hex := make([]string, len(d.Bytes))
+	for i, b := range d.Bytes {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return fmt.Sprintf("data %s = {%s}", name, strings.Join(hex, " "))
+}
+
+type decompiler struct {
+	*cfg
+	names map[uint16]string
+	funcs map[int]string // function names by entry
+
+	// Liveness summaries by entry, see liveness
+	uses    map[int]uint32
+	returns map[int]uint32
+}
+
+func (d *decompiler) label(addr uint16) string {
+	if name, ok := d.names[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("L_%03x", addr)
+}
+
+func (d *decompiler) address(addr uint16) string {
+	if name, ok := d.names[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%03x", addr)
+}
+
+func (d *decompiler) function(fn *function) *Function {
+	blocks, entry := fn.blocks, fn.entry
+	s := &structurer{
+		decompiler: d,
+		blocks:     blocks,
+		bodies:     map[*block]*body{},
+		suppressed: map[*block]bool{},
+		emitted:    map[*block]bool{},
+		gotos:      map[uint16]bool{},
+		f: &Function{
+			Name:   d.funcs[entry],
+			Entry:  uint16(entry),
+			lineOf: map[uint16]int{},
+		},
+	}
+	for _, b := range blocks {
+		s.bodies[b] = d.body(fn, b)
+	}
+
+	s.emit(0, uint16(entry), fmt.Sprintf("func %s() {", s.f.Name))
+	if blocks[0].start != entry {
+		s.jump(1, nil, "", entry, nil)
+	}
+	s.region(blocks[0].start, vm.MemSize, 1, nil)
+	s.overlapping()
+	s.emit(0, uint16(entry), "}")
+
+	// Only the jump targets left as gotos need their labels, the
+	// instructions of the other lines without text (NOPEs, jumps not shown)
+	// go with the next line
+	f := s.f
+	lines := f.Lines[:0]
+	var pending []uint16
+	for _, line := range f.Lines {
+		if line.Text == "" {
+			if len(line.addrs) > 0 || !s.gotos[line.Addr] {
+				pending = append(pending, line.addrs...)
+				continue
+			}
+			line.Text = s.label(line.Addr) + ":"
+			line.Depth--
+		}
+		line.addrs = append(line.addrs, pending...)
+		pending = nil
+		lines = append(lines, line)
+	}
+	f.Lines = lines
+	for k, line := range f.Lines {
+		for _, addr := range line.addrs {
+			f.lineOf[addr] = k
+		}
+	}
+	return f
+}
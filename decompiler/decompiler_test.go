@@ -0,0 +1,227 @@
+package decompiler
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/upryst/slede8dbg/assembler"
+)
+
+// branchProgram has an if, an if/else, a call and a FINN + LAST.
+const branchProgram = `
+    SETT r2, 0
+    SETT r6, 1
+    LES r3
+    SETT r4, 'q'
+    LIK r3, r4
+    BHOPP quit
+    TUR count
+    SETT r5, 10
+    SE r2, r5
+    BHOPP big
+    FINN small
+    HOPP print
+big:
+    FINN large
+print:
+    LAST r7
+    SKRIV r7
+quit:
+    STOPP
+count:
+    LES r3
+    LIK r3, r2
+    RETUR
+small:
+    .DATA "s"
+large:
+    .DATA 1, 2
+`
+
+// loopProgram reads 5 bytes and compares them to a secret.
+const loopProgram = `
+    SETT r5, 0
+    SETT r6, 1
+    SETT r7, 5
+    FINN buffer
+read:
+    LES r2
+    LAGR r2
+    PLUSS r0, r6
+    PLUSS r5, r6
+    LIK r5, r7
+    BHOPP compare
+    HOPP read
+compare:
+    SETT r5, 0
+check:
+    LIK r5, r7
+    BHOPP ok
+    FINN buffer
+    PLUSS r0, r5
+    LAST r2
+    FINN secret
+    PLUSS r0, r5
+    LAST r3
+    ULIK r2, r3
+    BHOPP fail
+    PLUSS r5, r6
+    HOPP check
+ok:
+    SETT r4, 0
+    SETT r2, '!'
+repeat:
+    SKRIV r2
+    PLUSS r4, r6
+    ME r4, r7
+    BHOPP repeat
+fail:
+    STOPP
+secret:
+    .DATA "s3cr!"
+buffer:
+`
+
+func decompile(t *testing.T, src string) *Listing {
+	listing := assembler.List(src)
+	if err := listing.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return Decompile(listing.Bytecode(), listing.Labels)
+}
+
+func TestDecompile(t *testing.T) {
+	expected := `func main() {
+    r2 = 0
+    r6 = 1
+    r3 = read()
+    if (r3 != 'q') {
+        count()
+        if (r2 <= 10) {
+            r1:r0 = &small
+        } else {
+            r1:r0 = &large
+        }
+        r7 = mem[r1:r0]
+        write(r7)
+    }
+    stop
+}
+
+func count() {
+    r3 = read()
+    flag = r3 == r2
+    return
+}
+
+data small = "s"
+data large = {01 02}
+`
+	if actual := decompile(t, branchProgram).String(); actual != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	expected := `func main() {
+    r5 = 0
+    r6 = 1
+    r7 = 5
+    r1:r0 = &buffer
+    while (true) {
+        r2 = read()
+        mem[r1:r0] = r2
+        r0 += r6
+        r5 += r6
+        if (r5 == r7) break
+    }
+    r5 = 0
+    while (r5 != r7) {
+        r2 = mem[buffer + r5]
+        r3 = mem[secret + r5]
+        if (r2 != r3) goto fail
+        r5 += r6
+    }
+    r4 = 0
+    r2 = '!'
+    do {
+        write(r2)
+        r4 += r6
+    } while (r4 < r7)
+fail:
+    stop
+}
+
+data secret = "s3cr!"
+`
+	if actual := decompile(t, loopProgram).String(); actual != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestLineAt(t *testing.T) {
+	listing := assembler.List(loopProgram)
+	decompiled := Decompile(listing.Bytecode(), listing.Labels)
+
+	lines := []struct {
+		addr uint16
+		text string
+	}{
+		{listing.Labels["read"] + 2, "mem[r1:r0] = r2"},
+		{listing.Labels["check"], "while (r5 != r7) {"},
+		{listing.Labels["check"] + 4, "r2 = mem[buffer + r5]"},
+		{listing.Labels["check"] + 8, "r2 = mem[buffer + r5]"},
+		{listing.Labels["repeat"] + 6, "} while (r4 < r7)"},
+	}
+	for _, line := range lines {
+		f := decompiled.FunctionAt(line.addr)
+		if f == nil {
+			t.Errorf("%03x: no function", line.addr)
+			continue
+		}
+		if text := f.Lines[f.LineAt(line.addr)].Text; text != line.text {
+			t.Errorf("%03x: expected %q, got %q", line.addr, line.text, text)
+		}
+	}
+
+	if f := decompiled.FunctionAt(listing.Labels["secret"]); f != nil {
+		t.Errorf("Expected no function at secret, got %s", f.Name)
+	}
+}
+
+// TestRandomCode checks every instruction reached is shown exactly once,
+// however unstructured the code.
+func TestRandomCode(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		code := make([]byte, r.Intn(200))
+		r.Read(code)
+		for i := 0; i+1 < len(code); i += 2 {
+			if r.Intn(3) == 0 {
+				code[i] = code[i]&0xf0 | byte(8+r.Intn(4)) // more jumps
+				code[i+1] &= 0
+			}
+		}
+
+		decompiled := Decompile(code, nil)
+		g := buildCFG(code)
+		for _, f := range decompiled.Functions {
+			shown := map[uint16]bool{}
+			for _, line := range f.Lines {
+				for _, addr := range line.addrs {
+					if shown[addr] {
+						t.Fatalf("%x: %03x shown twice in %s:\n%s", code, addr, f.Name, decompiled)
+					}
+					shown[addr] = true
+				}
+			}
+			for _, b := range g.function(int(f.Entry)).blocks {
+				for _, i := range b.instrs {
+					if f.LineAt(i.addr) < 0 {
+						t.Fatalf("%x: %03x missing in %s:\n%s", code, i.addr, f.Name, decompiled)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package decompiler
+
+import (
+	"sort"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// structurer lays out the blocks of a function by address, reading
+// if/else and loops off the branches: a BHOPP forward over a region is an
+// if (with an else when the region ends jumping further), a jump back to
+// an earlier block is a loop. Whatever doesn't fit becomes a goto.
+type structurer struct {
+	*decompiler
+	f          *Function
+	blocks     []*block // sorted by address
+	bodies     map[*block]*body
+	suppressed map[*block]bool // final jumps already shown by the structure
+	emitted    map[*block]bool
+	gotos      map[uint16]bool // targets needing a label
+}
+
+type loop struct {
+	header, exit int
+	latch        *block
+	continues    bool // jumping to the header is a continue
+}
+
+// emit adds a line for the instructions at addrs, addr being where it goes
+// in the code. Lines without text mark where blocks start, or stand for
+// instructions not shown.
+func (s *structurer) emit(depth int, addr uint16, text string, addrs ...uint16) {
+	s.f.Lines = append(s.f.Lines, Line{Addr: addr, Depth: depth, Text: text, addrs: addrs})
+}
+
+// index is the first block starting at addr or later.
+func (s *structurer) index(addr int) int {
+	return sort.Search(len(s.blocks), func(k int) bool { return s.blocks[k].start >= addr })
+}
+
+// region lays out the blocks starting from from up to to.
+func (s *structurer) region(from, to, depth int, lp *loop) {
+	for k := s.index(from); k < len(s.blocks) && s.blocks[k].start < to; {
+		b := s.blocks[k]
+		var next int
+		if latch := s.latch(b, to, lp); latch != nil {
+			next = s.loop(b, latch, depth)
+		} else {
+			next = s.block(b, to, depth, lp)
+		}
+		k = s.index(next)
+	}
+}
+
+// latch is the last block before to jumping back to b, making b a loop
+// header.
+func (s *structurer) latch(b *block, to int, lp *loop) *block {
+	if lp != nil && lp.header == b.start {
+		return nil
+	}
+	var latch *block
+	for k := s.index(b.start); k < len(s.blocks) && s.blocks[k].end <= to; k++ {
+		last := s.blocks[k].last()
+		if last.Class == vm.OpClassJmp || last.Class == vm.OpClassCondJmp {
+			if int(last.Addr) == b.start {
+				latch = s.blocks[k]
+			}
+		}
+	}
+	return latch
+}
+
+func (s *structurer) loop(b, latch *block, depth int) int {
+	lp := &loop{header: b.start, exit: latch.end, latch: latch}
+	s.suppressed[latch] = true
+	last := latch.last()
+
+	if last.Class == vm.OpClassCondJmp {
+		s.emit(depth, uint16(b.start), "do {")
+		s.region(b.start, latch.end, depth+1, lp)
+		s.emit(depth, last.addr, "} while ("+s.bodies[latch].cond.String()+")", s.bodies[latch].condAddrs...)
+		return latch.end
+	}
+
+	lp.continues = true
+	head := s.bodies[b]
+	if b != latch && head.empty() && b.last().Class == vm.OpClassCondJmp && int(b.last().Addr) == latch.end {
+		s.emitted[b] = true
+		s.emit(depth, uint16(b.start), "")
+		s.emit(depth, b.last().addr, "while ("+head.cond.not().String()+") {", head.condAddrs...)
+		s.region(b.end, latch.end, depth+1, lp)
+	} else {
+		s.emit(depth, uint16(b.start), "while (true) {")
+		s.region(b.start, latch.end, depth+1, lp)
+	}
+	s.emit(depth, last.addr, "}", last.addr)
+	return latch.end
+}
+
+// block lays out b and, when it ends with a BHOPP forward, the if around
+// the blocks skipped. It returns where to go on from.
+func (s *structurer) block(b *block, to, depth int, lp *loop) int {
+	s.emitted[b] = true
+	s.emit(depth, uint16(b.start), "")
+	bd := s.bodies[b]
+	for _, st := range bd.stmts {
+		s.emit(depth, st.addrs[0], st.text, st.addrs...)
+	}
+
+	last := b.last()
+	target := int(last.Addr)
+	if s.suppressed[b] {
+		return b.end
+	}
+	switch last.Class {
+	case vm.OpClassJmp:
+		// Over data to the next block is as good as falling through
+		if k := s.index(b.end); k < len(s.blocks) && s.blocks[k].start == target && target < to {
+			s.emit(depth, last.addr, "", last.addr)
+			break
+		}
+		s.jump(depth, []uint16{last.addr}, "", target, lp)
+
+	case vm.OpClassCondJmp:
+		if target == b.end {
+			s.emit(depth, last.addr, "", bd.condAddrs...)
+			break
+		}
+		if lp != nil && (target == lp.exit || target == lp.header) || target <= b.end || target > to {
+			s.jump(depth, bd.condAddrs, "if ("+bd.cond.String()+") ", target, lp)
+			break
+		}
+
+		s.emit(depth, last.addr, "if ("+bd.cond.not().String()+") {", bd.condAddrs...)
+		if tail := s.endingAt(target, b.end); tail != nil && tail.last().Class == vm.OpClassJmp && !s.suppressed[tail] {
+			join := int(tail.last().Addr)
+			if join > target && join <= to && (lp == nil || join != lp.exit && join != lp.header) {
+				s.suppressed[tail] = true
+				s.region(b.end, target, depth+1, lp)
+				s.emit(depth, tail.last().addr, "} else {", tail.last().addr)
+				s.region(target, join, depth+1, lp)
+				s.emit(depth, uint16(join), "}")
+				return join
+			}
+		}
+		s.region(b.end, target, depth+1, lp)
+		s.emit(depth, uint16(target), "}")
+		return target
+	}
+	return b.end
+}
+
+// overlapping lays out the blocks skipped for starting inside another
+// (jumps to odd addresses), each on its own.
+func (s *structurer) overlapping() {
+	for _, b := range s.blocks {
+		if s.emitted[b] {
+			continue
+		}
+		s.block(b, b.end, 1, nil)
+		if len(b.succs) > 0 && b.succs[0] == b.end && !b.last().ends() {
+			s.jump(1, nil, "", b.end, nil)
+		}
+	}
+}
+
+// endingAt is the block of the function ending at addr, starting at from
+// or later.
+func (s *structurer) endingAt(addr, from int) *block {
+	for k := s.index(from); k < len(s.blocks) && s.blocks[k].start < addr; k++ {
+		if s.blocks[k].end == addr {
+			return s.blocks[k]
+		}
+	}
+	return nil
+}
+
+// jump shows a jump (prefixed by its condition) as break, continue or goto.
+func (s *structurer) jump(depth int, addrs []uint16, prefix string, target int, lp *loop) {
+	addr := uint16(target)
+	if len(addrs) > 0 {
+		addr = addrs[len(addrs)-1]
+	}
+	switch {
+	case lp != nil && target == lp.exit:
+		s.emit(depth, addr, prefix+"break", addrs...)
+	case lp != nil && target == lp.header && lp.continues:
+		s.emit(depth, addr, prefix+"continue", addrs...)
+	default:
+		s.gotos[uint16(target)] = true
+		s.emit(depth, addr, prefix+"goto "+s.label(uint16(target)), addrs...)
+	}
+}
@@ -0,0 +1,201 @@
+// Package fuzzer searches for SLEDE8 inputs (føde) reaching new code, an
+// address, an output or a VM error, guided by the addresses executed.
+package fuzzer
+
+import (
+	"bytes"
+	"math/rand"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// What a Finding is about.
+const (
+	NewCode = "coverage"
+	Target  = "target"
+	Output  = "output"
+	Error   = "error"
+)
+
+// Options say what to look for, coverage is always collected.
+type Options struct {
+	CycleLimit int
+	MaxInput   int    // longest input tried
+	Target     int    // address to reach, -1 for none
+	Output     []byte // looked for anywhere in the output, nil for none
+	Errors     bool   // look for VM errors, except running out of input
+	Seed       int64
+}
+
+// Finding is an input which did something new.
+type Finding struct {
+	Kind  string
+	Input []byte
+	Err   error // for Error
+}
+
+type Fuzzer struct {
+	program []byte
+	opts    Options
+	rand    *rand.Rand
+
+	corpus  [][]byte
+	covered [vm.MemSize]bool
+	count   int // covered bytes
+	matched int // longest prefix of Output seen
+
+	Runs int
+}
+
+// New checks the program (with the .SLEDE8 header) loads and sets up an
+// empty corpus.
+func New(program []byte, opts Options) (*Fuzzer, error) {
+	if _, err := vm.NewVM(program, nil, opts.CycleLimit); err != nil {
+		return nil, err
+	}
+	if opts.MaxInput <= 0 {
+		opts.MaxInput = 64
+	}
+
+	return &Fuzzer{
+		program: program,
+		opts:    opts,
+		rand:    rand.New(rand.NewSource(opts.Seed)),
+	}, nil
+}
+
+// Covered is the number of program bytes executed by any input so far.
+func (f *Fuzzer) Covered() int {
+	return f.count
+}
+
+// Corpus is every input kept for mutating, the seeds first.
+func (f *Fuzzer) Corpus() [][]byte {
+	return f.corpus
+}
+
+// Add runs a seed input and keeps it whatever it covers.
+func (f *Fuzzer) Add(input []byte) []Finding {
+	findings, _ := f.try(input)
+	f.corpus = append(f.corpus, input)
+	return findings
+}
+
+// Next runs a mutation of the corpus, keeping it if it's interesting.
+func (f *Fuzzer) Next() []Finding {
+	// The latest input got the furthest, so it's the most promising
+	var input []byte
+	switch {
+	case len(f.corpus) == 0:
+		input = f.mutate(nil)
+	case f.rand.Intn(2) == 0:
+		input = f.mutate(f.corpus[len(f.corpus)-1])
+	default:
+		input = f.mutate(f.corpus[f.rand.Intn(len(f.corpus))])
+	}
+
+	findings, keep := f.try(input)
+	if keep {
+		f.corpus = append(f.corpus, input)
+	}
+	return findings
+}
+
+// try runs input, keep is true when it got further than any other input.
+func (f *Fuzzer) try(input []byte) (findings []Finding, keep bool) {
+	f.Runs++
+
+	m, _ := vm.NewVM(f.program, append([]byte{}, input...), f.opts.CycleLimit)
+	reached := false
+	for m.State == vm.Running {
+		if int(m.PC) == f.opts.Target {
+			reached = true
+			break
+		}
+		m.Step()
+	}
+
+	newCode := false
+	for addr := range f.covered {
+		if !f.covered[addr] && m.Executed(uint16(addr)) {
+			f.covered[addr] = true
+			f.count++
+			newCode = true
+		}
+	}
+	if newCode {
+		findings = append(findings, Finding{Kind: NewCode, Input: input})
+		keep = true
+	}
+
+	if reached {
+		findings = append(findings, Finding{Kind: Target, Input: input})
+	}
+
+	if len(f.opts.Output) > 0 {
+		matched := MatchedPrefix(m.Output, f.opts.Output)
+		if matched == len(f.opts.Output) {
+			findings = append(findings, Finding{Kind: Output, Input: input})
+		}
+		if matched > f.matched {
+			f.matched = matched
+			keep = true
+		}
+	}
+
+	if f.opts.Errors && m.State == vm.Error && vm.ErrorKindOf(m.LastError) != vm.KindNoMoreInput {
+		findings = append(findings, Finding{Kind: Error, Input: input, Err: m.LastError})
+	}
+	return findings, keep
+}
+
+// MatchedPrefix is the length of the longest prefix of want found in
+// output.
+func MatchedPrefix(output, want []byte) int {
+	n := 0
+	for n < len(want) && bytes.Contains(output, want[:n+1]) {
+		n++
+	}
+	return n
+}
+
+var interesting = []byte{0x00, 0x01, 0x7f, 0x80, 0xff, ' ', '\n', '0', 'A', 'Z', 'a', 'z', '{', '}'}
+
+func (f *Fuzzer) mutate(input []byte) []byte {
+	out := append([]byte{}, input...)
+	r := f.rand
+
+	for n := 1 + r.Intn(3); n > 0; n-- {
+		pos := 0
+		if len(out) > 0 {
+			pos = r.Intn(len(out))
+		}
+
+		switch op := r.Intn(7); {
+		case op == 0 && len(out) > 0:
+			out[pos] ^= 1 << uint(r.Intn(8))
+		case op == 1 && len(out) > 0:
+			out[pos] = byte(r.Intn(256))
+		case op == 2 && len(out) > 0:
+			out[pos] = interesting[r.Intn(len(interesting))]
+		case op == 3:
+			out = append(out[:pos], append([]byte{byte(r.Intn(256))}, out[pos:]...)...)
+		case op == 4 && len(out) > 0:
+			out = append(out[:pos], out[pos+1:]...)
+		case op == 5 && len(f.corpus) > 0:
+			other := f.corpus[r.Intn(len(f.corpus))]
+			if len(other) > pos {
+				out = append(out[:pos], other[pos:]...)
+			}
+		default:
+			for i := 1 + r.Intn(4); i > 0; i-- {
+				out = append(out, byte(' '+r.Intn(95)))
+			}
+		}
+	}
+
+	if len(out) > f.opts.MaxInput {
+		out = out[:f.opts.MaxInput]
+	}
+	return out
+}
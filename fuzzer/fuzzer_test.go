@@ -0,0 +1,108 @@
+package fuzzer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// passwordProgram prints "OK" for input starting with "hi!", and fails
+// (RETUR on an empty stack) for input starting with 'x'.
+const passwordProgram = `
+    LES r2
+    SETT r3, 'x'
+    LIK r2, r3
+    BHOPP crash
+    SETT r3, 'h'
+    LIK r2, r3
+    BHOPP second
+    STOPP
+second:
+    LES r2
+    SETT r3, 'i'
+    LIK r2, r3
+    BHOPP third
+    STOPP
+third:
+    LES r2
+    SETT r3, '!'
+    LIK r2, r3
+    BHOPP ok
+    STOPP
+ok:
+    SETT r4, 'O'
+    SKRIV r4
+    SETT r4, 'K'
+    SKRIV r4
+    STOPP
+crash:
+    RETUR
+`
+
+func newFuzzer(t *testing.T, opts Options) (*Fuzzer, map[string]uint16) {
+	listing := assembler.List(passwordProgram)
+	if err := listing.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.CycleLimit, opts.Target = 100, -1
+	f, err := New(append([]byte(vm.SledeHeader), listing.Bytecode()...), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, listing.Labels
+}
+
+// search runs the fuzzer until it finds kind.
+func search(t *testing.T, f *Fuzzer, kind string) Finding {
+	f.Add(nil)
+	for f.Runs < 200000 {
+		for _, finding := range f.Next() {
+			if finding.Kind == kind {
+				return finding
+			}
+		}
+	}
+	t.Fatalf("No %s found after %d runs, covered %d bytes", kind, f.Runs, f.Covered())
+	return Finding{}
+}
+
+func TestOutput(t *testing.T) {
+	f, _ := newFuzzer(t, Options{Output: []byte("OK")})
+	finding := search(t, f, Output)
+	if !bytes.HasPrefix(finding.Input, []byte("hi!")) {
+		t.Errorf("Unexpected input %q", finding.Input)
+	}
+}
+
+func TestTarget(t *testing.T) {
+	f, labels := newFuzzer(t, Options{})
+	f.opts.Target = int(labels["third"])
+	finding := search(t, f, Target)
+	if !bytes.HasPrefix(finding.Input, []byte("hi")) {
+		t.Errorf("Unexpected input %q", finding.Input)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	f, _ := newFuzzer(t, Options{Errors: true})
+	finding := search(t, f, Error)
+	if finding.Input[0] != 'x' || vm.ErrorKindOf(finding.Err) != vm.KindEmptyStack {
+		t.Errorf("Unexpected input %q (%v)", finding.Input, finding.Err)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	f, _ := newFuzzer(t, Options{})
+	f.Add([]byte("hi!"))
+	for i := 0; i < 1000; i++ {
+		f.Next()
+	}
+
+	// All 24 instructions
+	if f.Covered() != 2*24 {
+		t.Errorf("Expected 48 bytes covered, got %d", f.Covered())
+	}
+}
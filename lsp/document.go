@@ -0,0 +1,294 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+type document struct {
+	uri     string
+	text    string
+	listing *assembler.Listing
+}
+
+func newDocument(uri, text string) *document {
+	return &document{
+		uri:     uri,
+		text:    text,
+		listing: assembler.List(text),
+	}
+}
+
+func (d *document) line(n int) *assembler.Line {
+	if n < 0 || n >= len(d.listing.Lines) {
+		return nil
+	}
+	return d.listing.Lines[n]
+}
+
+// LSP positions count UTF-16 code units, Go strings are indexed by bytes.
+func toUTF16(s string, offset int) int {
+	col := 0
+	for _, r := range s[:offset] {
+		col += len(utf16.Encode([]rune{r}))
+	}
+	return col
+}
+
+func fromUTF16(s string, col int) int {
+	for i, r := range s {
+		if col <= 0 {
+			return i
+		}
+		col -= len(utf16.Encode([]rune{r}))
+	}
+	return len(s)
+}
+
+func (d *document) rangeOf(line *assembler.Line, start, end int) Range {
+	return Range{
+		Start: Position{line.Number - 1, toUTF16(line.Text, start)},
+		End:   Position{line.Number - 1, toUTF16(line.Text, end)},
+	}
+}
+
+// codeEnd returns the byte offset where the comment (if any) starts.
+func codeEnd(text string) int {
+	inString := false
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"':
+			inString = !inString
+		case '\\':
+			if inString {
+				i++
+			}
+		case ';':
+			if !inString {
+				return i
+			}
+		}
+	}
+	return len(text)
+}
+
+func (d *document) lineRange(line *assembler.Line) Range {
+	end := len(strings.TrimRightFunc(line.Text[:codeEnd(line.Text)], unicode.IsSpace))
+	start := len(line.Text) - len(strings.TrimLeftFunc(line.Text, unicode.IsSpace))
+	if start > end {
+		start = end
+	}
+	return d.rangeOf(line, start, end)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordAt returns the word under the (byte) offset, along with its bounds.
+func wordAt(text string, offset int) (word string, start, end int) {
+	start, end = offset, offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if !isWordRune(r) {
+			break
+		}
+		start -= size
+	}
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(r) {
+			break
+		}
+		end += size
+	}
+	return text[start:end], start, end
+}
+
+// findWord returns the byte offset of the first whole word occurrence of
+// word in the code part of text, or -1.
+func findWord(text, word string) int {
+	code := text[:codeEnd(text)]
+	for from := 0; from < len(code); {
+		i := strings.Index(code[from:], word)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		if w, _, _ := wordAt(code, i); w == word {
+			return i
+		}
+		from = i + len(word)
+	}
+	return -1
+}
+
+func (d *document) labelAt(pos Position) (string, *assembler.Line) {
+	line := d.line(pos.Line)
+	if line == nil {
+		return "", nil
+	}
+
+	word, _, _ := wordAt(line.Text, fromUTF16(line.Text, pos.Character))
+	if _, found := d.listing.Labels[word]; !found {
+		return "", line
+	}
+	return word, line
+}
+
+func (d *document) definition(label string) *Location {
+	for _, line := range d.listing.Lines {
+		if line.Label == label {
+			start := findWord(line.Text, label)
+			return &Location{d.uri, d.rangeOf(line, start, start+len(label))}
+		}
+	}
+	return nil
+}
+
+func (d *document) references(label string, includeDeclaration bool) []Location {
+	locations := []Location{}
+	for _, line := range d.listing.Lines {
+		if line.LabelRef == label || (includeDeclaration && line.Label == label) {
+			// Skip the mnemonic, a label could be named e.g. "HOPP"
+			offset := 0
+			if line.LabelRef == label {
+				offset = strings.Index(line.Text, line.Mnemonic) + len(line.Mnemonic)
+			}
+			start := findWord(line.Text[offset:], label)
+			if start < 0 {
+				continue
+			}
+			start += offset
+			locations = append(locations,
+				Location{d.uri, d.rangeOf(line, start, start+len(label))})
+		}
+	}
+	return locations
+}
+
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, line := range d.listing.Lines {
+		if line.Err != nil {
+			diagnostics = append(diagnostics, Diagnostic{
+				Range:    d.lineRange(line),
+				Severity: SeverityError,
+				Source:   "slede8",
+				Message:  line.Err.Error(),
+			})
+		}
+	}
+	return diagnostics
+}
+
+func formatBytes(addr uint16, bytecode []byte) string {
+	var text strings.Builder
+	for i := 0; i < len(bytecode); i += 8 {
+		text.WriteString(fmt.Sprintf("0x%03x:", int(addr)+i))
+		for j := i; j < i+8 && j < len(bytecode); j++ {
+			text.WriteString(fmt.Sprintf(" %02x", bytecode[j]))
+		}
+		text.WriteByte('\n')
+	}
+	return text.String()
+}
+
+func (d *document) hover(pos Position) *Hover {
+	label, line := d.labelAt(pos)
+	if line == nil {
+		return nil
+	}
+
+	if label != "" {
+		return &Hover{Contents: MarkupContent{"markdown",
+			fmt.Sprintf("`%s` = `0x%03x`", label, d.listing.Labels[label])}}
+	}
+
+	if line.Err != nil || len(line.Bytecode) == 0 {
+		return nil
+	}
+
+	value := "```\n" + formatBytes(line.Addr, line.Bytecode) + "```"
+	if len(line.Bytecode) == 2 && !strings.EqualFold(line.Mnemonic, ".DATA") {
+		instr := vm.ParseInstruction(uint16(line.Bytecode[0]) | uint16(line.Bytecode[1])<<8)
+		value += "\n" + instr.String()
+	}
+
+	r := d.lineRange(line)
+	return &Hover{Contents: MarkupContent{"markdown", value}, Range: &r}
+}
+
+func (d *document) completion(pos Position) []CompletionItem {
+	items := []CompletionItem{}
+
+	line := d.line(pos.Line)
+	if line == nil {
+		return items
+	}
+
+	prefix := strings.TrimLeftFunc(line.Text[:fromUTF16(line.Text, pos.Character)],
+		unicode.IsSpace)
+
+	if strings.IndexFunc(prefix, unicode.IsSpace) < 0 {
+		for _, mnemonic := range assembler.Mnemonics {
+			items = append(items, CompletionItem{Label: mnemonic, Kind: CompletionKindKeyword})
+		}
+		return items
+	}
+
+	switch mnemonic := strings.ToUpper(strings.Fields(prefix)[0]); mnemonic {
+	case "FINN", "HOPP", "BHOPP", "TUR":
+		for label, addr := range d.listing.Labels {
+			items = append(items, CompletionItem{
+				Label:  label,
+				Kind:   CompletionKindReference,
+				Detail: fmt.Sprintf("0x%03x", addr),
+			})
+		}
+	case "STOPP", "RETUR", "NOPE", ".DATA":
+	default:
+		for i := 0; i < vm.RegCount; i++ {
+			items = append(items, CompletionItem{Label: fmt.Sprintf("r%d", i),
+				Kind: CompletionKindVariable})
+		}
+	}
+
+	return items
+}
+
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for i, line := range d.listing.Lines {
+		if line.Label == "" {
+			continue
+		}
+
+		kind := SymbolKindFunction
+		for _, next := range d.listing.Lines[i+1:] {
+			if next.Mnemonic != "" {
+				if strings.EqualFold(next.Mnemonic, ".DATA") {
+					kind = SymbolKindVariable
+				}
+				break
+			}
+		}
+
+		start := findWord(line.Text, line.Label)
+		selection := d.rangeOf(line, start, start+len(line.Label))
+		symbols = append(symbols, DocumentSymbol{
+			Name:           line.Label,
+			Detail:         fmt.Sprintf("0x%03x", d.listing.Labels[line.Label]),
+			Kind:           kind,
+			Range:          d.lineRange(line),
+			SelectionRange: selection,
+		})
+	}
+	return symbols
+}
@@ -0,0 +1,133 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const jsonrpcVersion = "2.0"
+
+// maxContentLength bounds the body of a message, sources are far smaller
+const maxContentLength = 16 << 20
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// conn reads and writes LSP base protocol messages
+// (Content-Length framed JSON-RPC).
+type conn struct {
+	r *bufio.Reader
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: bufio.NewReader(r), w: w}
+}
+
+func (c *conn) read() (*message, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 || length > maxContentLength {
+		return nil, errors.Errorf("Bad Content-Length header: %q",
+			header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, &responseError{codeParseError, err.Error()}
+	}
+
+	return &msg, nil
+}
+
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = jsonrpcVersion
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(body)
+	return err
+}
+
+// reply responds to the request with the given id, nil when it couldn't be
+// read (the response then has "id": null).
+func (c *conn) reply(id *json.RawMessage, result interface{}, err error) error {
+	if id == nil {
+		null := json.RawMessage("null")
+		id = &null
+	}
+	msg := &message{ID: id}
+
+	if err != nil {
+		if respErr, ok := err.(*responseError); ok {
+			msg.Error = respErr
+		} else {
+			msg.Error = &responseError{codeInternalError, err.Error()}
+		}
+	} else if result == nil {
+		// "result" is mandatory for successful responses
+		msg.Result = json.RawMessage("null")
+	} else {
+		msg.Result = result
+	}
+
+	return c.write(msg)
+}
+
+func (c *conn) notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(&message{Method: method, Params: raw})
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
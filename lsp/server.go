@@ -0,0 +1,200 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Server is a Language Server Protocol server for SLEDE8 assembly.
+// Documents are analyzed with assembler.List, diagnostics are published
+// when a document is opened or saved.
+type Server struct {
+	conn *conn
+	docs map[string]*document
+
+	shutdown bool
+}
+
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		conn: newConn(r, w),
+		docs: make(map[string]*document),
+	}
+}
+
+// Serve processes messages until the client sends "exit" or closes
+// the connection.
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		} else if respErr, ok := err.(*responseError); ok {
+			if err := s.conn.reply(nil, nil, respErr); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("Exit requested without shutdown")
+			}
+			return nil
+		}
+
+		result, err := s.handle(msg.Method, msg.Params)
+		if msg.ID == nil {
+			// Notifications don't get responses
+			continue
+		}
+
+		if err := s.conn.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func unmarshalParams(raw json.RawMessage, params interface{}) error {
+	if err := json.Unmarshal(raw, params); err != nil {
+		return &responseError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) handle(method string, raw json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		var result InitializeResult
+		caps := &result.Capabilities
+		caps.TextDocumentSync.OpenClose = true
+		caps.TextDocumentSync.Change = TextDocumentSyncFull
+		caps.TextDocumentSync.Save.IncludeText = true
+		caps.DefinitionProvider = true
+		caps.ReferencesProvider = true
+		caps.HoverProvider = true
+		caps.DocumentSymbolProvider = true
+		caps.CompletionProvider.TriggerCharacters = []string{" ", ","}
+		result.ServerInfo.Name = "slede8dbg"
+		return result, nil
+
+	case "initialized":
+		return nil, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		doc := newDocument(params.TextDocument.URI, params.TextDocument.Text)
+		s.docs[doc.uri] = doc
+		return nil, s.publishDiagnostics(doc)
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			uri := params.TextDocument.URI
+			s.docs[uri] = newDocument(uri, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didSave":
+		var params DidSaveTextDocumentParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		uri := params.TextDocument.URI
+		if params.Text != nil {
+			s.docs[uri] = newDocument(uri, *params.Text)
+		}
+		if doc, found := s.docs[uri]; found {
+			return nil, s.publishDiagnostics(doc)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, nil
+
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		if doc, found := s.docs[params.TextDocument.URI]; found {
+			if label, _ := doc.labelAt(params.Position); label != "" {
+				return doc.definition(label), nil
+			}
+		}
+		return nil, nil
+
+	case "textDocument/references":
+		var params ReferenceParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		if doc, found := s.docs[params.TextDocument.URI]; found {
+			if label, _ := doc.labelAt(params.Position); label != "" {
+				return doc.references(label, params.Context.IncludeDeclaration), nil
+			}
+		}
+		return nil, nil
+
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		if doc, found := s.docs[params.TextDocument.URI]; found {
+			if hover := doc.hover(params.Position); hover != nil {
+				return hover, nil
+			}
+		}
+		return nil, nil
+
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		list := CompletionList{Items: []CompletionItem{}}
+		if doc, found := s.docs[params.TextDocument.URI]; found {
+			list.Items = doc.completion(params.Position)
+		}
+		return list, nil
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := unmarshalParams(raw, &params); err != nil {
+			return nil, err
+		}
+		if doc, found := s.docs[params.TextDocument.URI]; found {
+			return doc.symbols(), nil
+		}
+		return []DocumentSymbol{}, nil
+	}
+
+	return nil, &responseError{codeMethodNotFound, "Method not found: " + method}
+}
+
+func (s *Server) publishDiagnostics(doc *document) error {
+	return s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         doc.uri,
+		Diagnostics: doc.diagnostics(),
+	})
+}
@@ -0,0 +1,213 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///test.asm"
+
+const testSource = `    HOPP start
+
+hello:
+    .DATA "Hi", 0
+
+start:
+    FINN hello
+    SETT r11, 1
+    HOPP start
+    BOGUS r1
+`
+
+type testClient struct {
+	t      *testing.T
+	conn   *conn
+	nextID int
+}
+
+func newTestClient(t *testing.T) (*testClient, chan error) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(serverR, serverW).Serve()
+		serverW.Close()
+	}()
+
+	return &testClient{t: t, conn: newConn(clientR, clientW)}, done
+}
+
+func (c *testClient) send(method string, params interface{}, withID bool) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	msg := &message{Method: method, Params: raw}
+	if withID {
+		c.nextID++
+		id := json.RawMessage(strconv.Itoa(c.nextID))
+		msg.ID = &id
+	}
+
+	if err := c.conn.write(msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) receive(result interface{}) *message {
+	msg, err := c.conn.read()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var raw struct {
+		Result json.RawMessage `json:"result"`
+		Params json.RawMessage `json:"params"`
+	}
+	body := mustMarshal(c.t, msg)
+	if err := json.Unmarshal(body, &raw); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if result != nil {
+		data := raw.Result
+		if msg.Method != "" {
+			data = raw.Params
+		}
+		if err := json.Unmarshal(data, result); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return msg
+}
+
+func (c *testClient) call(method string, params, result interface{}) {
+	c.send(method, params, true)
+	if msg := c.receive(result); msg.Error != nil {
+		c.t.Fatalf("%s: %s", method, msg.Error.Message)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func position(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{testURI},
+		Position:     Position{line, character},
+	}
+}
+
+func TestServer(t *testing.T) {
+	client, done := newTestClient(t)
+
+	var init InitializeResult
+	client.call("initialize", struct{}{}, &init)
+	if !init.Capabilities.DefinitionProvider || !init.Capabilities.HoverProvider {
+		t.Errorf("Unexpected capabilities: %+v", init.Capabilities)
+	}
+	client.send("initialized", struct{}{}, false)
+
+	client.send("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: testURI, LanguageID: "slede8", Text: testSource},
+	}, false)
+
+	var diags PublishDiagnosticsParams
+	if msg := client.receive(&diags); msg.Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("Expected diagnostics, got %s", msg.Method)
+	}
+	if len(diags.Diagnostics) != 1 || diags.Diagnostics[0].Range.Start.Line != 9 {
+		t.Errorf("Expected a single diagnostic on line 9, got %+v", diags.Diagnostics)
+	}
+
+	// "HOPP start" -> "start:"
+	var def Location
+	client.call("textDocument/definition", position(0, 10), &def)
+	if def.Range.Start != (Position{5, 0}) || def.Range.End != (Position{5, 5}) {
+		t.Errorf("Unexpected definition: %+v", def)
+	}
+
+	var refs []Location
+	client.call("textDocument/references", ReferenceParams{
+		TextDocumentPositionParams: position(5, 2),
+	}, &refs)
+	if len(refs) != 2 || refs[0].Range.Start != (Position{0, 9}) ||
+		refs[1].Range.Start != (Position{8, 9}) {
+		t.Errorf("Unexpected references: %+v", refs)
+	}
+
+	var hover Hover
+	client.call("textDocument/hover", position(7, 6), &hover)
+	if !strings.Contains(hover.Contents.Value, "0x007: b1 01") {
+		t.Errorf("Unexpected hover: %q", hover.Contents.Value)
+	}
+
+	var completion CompletionList
+	client.call("textDocument/completion", position(6, 9), &completion)
+	if len(completion.Items) != 2 {
+		t.Errorf("Expected label completions, got %+v", completion.Items)
+	}
+
+	var regCompletion CompletionList
+	client.call("textDocument/completion", position(7, 9), &regCompletion)
+	if len(regCompletion.Items) != 16 || regCompletion.Items[0].Label != "r0" {
+		t.Errorf("Expected register completions, got %+v", regCompletion.Items)
+	}
+
+	var symbols []DocumentSymbol
+	client.call("textDocument/documentSymbol",
+		DocumentSymbolParams{TextDocumentIdentifier{testURI}}, &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "hello" ||
+		symbols[0].Kind != SymbolKindVariable || symbols[1].Kind != SymbolKindFunction {
+		t.Errorf("Unexpected symbols: %+v", symbols)
+	}
+
+	client.call("shutdown", nil, nil)
+	client.send("exit", nil, false)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	for _, length := range []string{"-1", "abc", strconv.Itoa(maxContentLength + 1)} {
+		c := newConn(strings.NewReader("Content-Length: "+length+"\r\n\r\n{}"), ioutil.Discard)
+		if _, err := c.read(); err == nil || !strings.HasPrefix(err.Error(), "Bad Content-Length") {
+			t.Errorf("%s: expected a Bad Content-Length error, got %v", length, err)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	body := "{not json"
+	in := "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	var out strings.Builder
+	if err := NewServer(strings.NewReader(in), &out).Serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := out.String()
+	reply = reply[strings.Index(reply, "\r\n\r\n")+4:]
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(reply), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := msg["id"]; !ok || string(id) != "null" {
+		t.Errorf(`Expected "id": null, got %s`, reply)
+	}
+	if !strings.Contains(string(msg["error"]), strconv.Itoa(codeParseError)) {
+		t.Errorf("Expected a parse error, got %s", reply)
+	}
+}
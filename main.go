@@ -1,67 +1,859 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/batch"
+	"github.com/upryst/slede8dbg/bruteforce"
+	"github.com/upryst/slede8dbg/console"
 	"github.com/upryst/slede8dbg/debugger"
+	"github.com/upryst/slede8dbg/decompiler"
+	"github.com/upryst/slede8dbg/fuzzer"
+	"github.com/upryst/slede8dbg/lsp"
+	"github.com/upryst/slede8dbg/symbolic"
 	"github.com/upryst/slede8dbg/vm"
 
+	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 )
 
 const (
 	defaultCycleLimit = 50000
 
-	asmExtension = ".asm"
+	asmExtension     = ".asm"
+	sessionExtension = ".session"
 )
 
-func compileAsmFile(path string) ([]byte, error) {
+func compileAsmFile(path string) ([]byte, *assembler.Listing, error) {
 	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listing := assembler.List(string(source))
+	if err := listing.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var binary bytes.Buffer
+	binary.Write([]byte(vm.SledeHeader))
+	binary.Write(listing.Bytecode())
+
+	return binary.Bytes(), listing, nil
+}
+
+// loadProgram reads a SLEDE8 binary or compiles an .asm source, the
+// listing is empty for the former.
+func loadProgram(path string) (binary []byte, listing *assembler.Listing, err error) {
+	if filepath.Ext(path) == asmExtension {
+		return compileAsmFile(path)
+	}
+
+	binary, err = ioutil.ReadFile(path)
+	return binary, &assembler.Listing{}, err
+}
+
+type compileOptions struct {
+	output     string
+	optimize   bool
+	input      string // hex, run to compare the cycles when optimizing
+	cycleLimit int
+}
+
+// compile writes the binary of an .asm source, optionally optimized in
+// which case the savings are reported on stderr.
+func compile(path string, opts compileOptions) error {
+	binary, listing, err := compileAsmFile(path)
+	if err != nil {
+		return err
+	}
+
+	if opts.optimize {
+		optimized, savings, err := listing.Optimize()
+		if err != nil {
+			return err
+		}
+		original := binary
+		binary = append([]byte(vm.SledeHeader), optimized.Bytecode()...)
+		fmt.Fprintf(os.Stderr, "Optimized: %v\n", savings)
+
+		input, err := hex.DecodeString(opts.input)
+		if err != nil {
+			return err
+		}
+		before, err := vm.NewVM(original, input, opts.cycleLimit)
+		if err != nil {
+			return err
+		}
+		after, err := vm.NewVM(binary, append([]byte{}, input...), opts.cycleLimit)
+		if err != nil {
+			return err
+		}
+		before.RunFast()
+		after.RunFast()
+
+		fmt.Fprintf(os.Stderr, "Cycles with input %q: %d -> %d (%s)\n",
+			opts.input, before.CycleCount, after.CycleCount, after.State)
+		if before.State != after.State || !bytes.Equal(before.Output, after.Output) {
+			fmt.Fprintf(os.Stderr, "Warning: the optimized program behaves differently (%s, output %x instead of %s, %x), is code read as data?\n",
+				after.State, after.Output, before.State, before.Output)
+		}
+	}
+
+	return ioutil.WriteFile(opts.output, binary, 0644)
+}
+
+type debugOptions struct {
+	input      string // hex, overrides the input saved in the session
+	cycleLimit int
+	script     string
+	state      string
+	noSession  bool
+	check      string
+	stackLimit int
+	// Whether --stack-limit was given, a loaded state keeps its own limit
+	// otherwise
+	stackLimitSet bool
+
+	interactiveInput bool
+}
+
+// legacyDebugOptions are the options of the alternative syntax,
+// slede8dbg <path> [<input> [cycle limit]], args being what follows the
+// path.
+func legacyDebugOptions(args []string) (debugOptions, error) {
+	opts := debugOptions{
+		cycleLimit: defaultCycleLimit,
+		stackLimit: vm.NPSTStackLimit,
+	}
+	if len(args) > 0 {
+		opts.input = args[0]
+	}
+	if len(args) > 1 {
+		var err error
+		if opts.cycleLimit, err = strconv.Atoi(args[1]); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// configure applies the options to m, the VM as loaded (from the program
+// or a state).
+func (opts debugOptions) configure(m *vm.VM) error {
+	m.InteractiveInput = opts.interactiveInput
+	if opts.state == "" || opts.stackLimitSet {
+		m.StackLimit = opts.stackLimit
+	}
+	return setMemoryChecks(m, opts.check)
+}
+
+// debug runs the debugger UI, the session (break/watchpoints, pane
+// offsets, input) is restored from and saved to a file next to the
+// program unless disabled.
+func debug(path string, opts debugOptions) error {
+	session := &debugger.Session{}
+	sessionPath := path + sessionExtension
+
+	if !opts.noSession {
+		var err error
+		if session, err = debugger.LoadSession(sessionPath); err != nil {
+			return err
+		}
+		if opts.input == "" {
+			opts.input = session.Input
+		}
+	}
+
+	input, err := hex.DecodeString(opts.input)
+	if err != nil {
+		return err
+	}
+
+	binary, listing, err := loadProgram(path)
+	if err != nil {
+		return err
+	}
+
+	ui, err := debugger.NewUI(binary, input, opts.cycleLimit, listing.Labels)
+	if err != nil {
+		return err
+	}
+
+	if opts.state != "" {
+		state, err := loadState(opts.state)
+		if err != nil {
+			return err
+		}
+		ui.SetVM(state)
+	}
+	if err := opts.configure(ui.VM()); err != nil {
+		return err
+	}
+	ui.RestoreSession(session, listing)
+
+	if opts.script != "" {
+		script, err := ioutil.ReadFile(opts.script)
+		if err != nil {
+			return err
+		}
+		ui.ExecScript(string(script))
+	}
+
+	if err := ui.MainLoop(); err != nil {
+		return err
+	}
+
+	if !opts.noSession {
+		return ui.Session(listing).Save(sessionPath)
+	}
+	return nil
+}
+
+func loadState(path string) (*vm.VM, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+
+	return vm.LoadState(f)
+}
+
+// statediff prints the differences between two VM snapshots, the exit
+// status is 1 if there are any.
+func statediff(pathA, pathB string) error {
+	a, err := loadState(pathA)
+	if err != nil {
+		return err
+	}
+
+	b, err := loadState(pathB)
+	if err != nil {
+		return err
+	}
+
+	diff := vm.DiffStates(a, b)
+	if len(diff) == 0 {
+		return nil
+	}
+
+	for _, line := range diff {
+		fmt.Println(line)
+	}
+	return cli.NewExitError("", 1)
+}
+
+type runOptions struct {
+	input      string
+	cycleLimit int
+	script     string
+	hexOutput  bool
+	jsonOutput bool
+	stdin      bool // read input from stdin once input is consumed
+	check      string
+	stackLimit int
+}
+
+// run executes the program without the UI, either straight to the end
+// (streaming its output to stdout) or driven by a console script.
+func run(path string, opts runOptions) error {
+	input, err := hex.DecodeString(opts.input)
+	if err != nil {
+		return err
+	}
+
+	binary, listing, err := loadProgram(path)
+	if err != nil {
+		return err
+	}
+
+	session, err := console.NewSession(binary, input, opts.cycleLimit)
+	if err != nil {
+		return err
+	}
+
+	session.VM().StackLimit = opts.stackLimit
+	if opts.stdin {
+		session.VM().InputSource = vm.ReaderInput(os.Stdin)
+	}
+	if err := setMemoryChecks(session.VM(), opts.check); err != nil {
+		return err
+	}
+
+	if opts.script != "" && opts.jsonOutput {
+		return cli.NewExitError("--json can't be combined with --script", 1)
+	} else if opts.jsonOutput {
+		return runJSON(session.VM())
+	}
+
+	if opts.script != "" {
+		script, err := os.Open(opts.script)
+		if err != nil {
+			return err
+		}
+		defer script.Close()
+
+		return console.NewConsole(session, listing.Labels, os.Stdout).ExecScript(script)
+	}
+
+	if opts.hexOutput {
+		session.VM().OutputSink = vm.WriterOutput(hex.NewEncoder(os.Stdout))
+		defer fmt.Println()
+	} else {
+		session.VM().OutputSink = vm.WriterOutput(os.Stdout)
+	}
+
+	if session.VM().CheckMemory {
+		err := runChecked(session.VM(), func(w vm.Warning) {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		})
+		if err == nil && session.VM().State == vm.Running {
+			return cli.NewExitError("", 1)
+		}
+		return err
+	}
+	return session.VM().RunFast()
+}
+
+// runResult is the outcome of run --json.
+type runResult struct {
+	State    string      `json:"state"`
+	PC       uint16      `json:"pc"`
+	Cycles   int         `json:"cycles"`
+	Output   string      `json:"output"`
+	Warnings []string    `json:"warnings,omitempty"`
+	Error    *vm.VMError `json:"error,omitempty"`
+}
+
+// runJSON runs to the end and prints a runResult, the exit status is 1
+// after an error or breaking on a warning.
+func runJSON(m *vm.VM) error {
+	var result runResult
+
+	var err error
+	if m.CheckMemory {
+		err = runChecked(m, func(w vm.Warning) {
+			result.Warnings = append(result.Warnings, w.String())
+		})
+	} else {
+		err = m.RunFast()
+	}
+	if err != nil && !errors.As(err, &result.Error) {
+		return err
+	}
+
+	result.State = m.State.String()
+	result.PC = m.PC
+	result.Cycles = m.CycleCount
+	result.Output = hex.EncodeToString(m.Output)
+
+	data, err := json.Marshal(&result)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+
+	if m.State != vm.Stopped {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
+
+// setMemoryChecks enables the memory checks, mode is "warn" or "break".
+func setMemoryChecks(m *vm.VM, mode string) error {
+	switch mode {
+	case "":
+	case "warn":
+		m.CheckMemory = true
+	case "break":
+		m.CheckMemory, m.BreakOnWarning = true, true
+	default:
+		return cli.NewExitError("--check must be warn or break", 1)
+	}
+	return nil
+}
+
+// runChecked runs to the end with the memory checks, warn is called for
+// every warning. When breaking on them it returns after the first one.
+func runChecked(m *vm.VM, warn func(vm.Warning)) error {
+	for m.State == vm.Running {
+		if err := m.Step(); err != nil {
+			return err
+		}
+
+		if w, found := m.Warning(); found {
+			warn(w)
+			if m.BreakOnWarning {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+type fuzzOptions struct {
+	input      string // hex seed
+	cycleLimit int
+	target     string // label or address
+	output     string
+	outputHex  string
+	errors     bool
+	corpus     string
+	runs       int
+	duration   time.Duration
+	maxInput   int
+	seed       int64
+}
+
+// fuzz mutates the input until reaching the target, output or an error.
+// Inputs covering new code and the findings are saved to the corpus
+// directory, whose inputs are the seeds of the next run.
+func fuzz(path string, opts fuzzOptions) error {
+	binary, listing, err := loadProgram(path)
+	if err != nil {
+		return err
+	}
+
+	target := -1
+	if opts.target != "" {
+		addr, err := parseAddress(opts.target, listing.Labels)
+		if err != nil {
+			return err
+		}
+		target = int(addr)
+	}
+
+	want := []byte(opts.output)
+	if opts.outputHex != "" {
+		if want, err = hex.DecodeString(opts.outputHex); err != nil {
+			return err
+		}
+	}
+
+	f, err := fuzzer.New(binary, fuzzer.Options{
+		CycleLimit: opts.cycleLimit,
+		MaxInput:   opts.maxInput,
+		Target:     target,
+		Output:     want,
+		Errors:     opts.errors,
+		Seed:       opts.seed,
+	})
+	if err != nil {
+		return err
+	}
+
+	corpus := opts.corpus
+	if corpus == "" {
+		corpus = path + ".corpus"
+	}
+	seeds, err := loadCorpus(corpus)
+	if err != nil {
+		return err
+	}
+	input, err := hex.DecodeString(opts.input)
+	if err != nil {
+		return err
+	}
+	seeds = append([][]byte{input}, seeds...)
+
+	found := false
+	handle := func(findings []fuzzer.Finding) error {
+		for _, finding := range findings {
+			saved, err := saveFinding(corpus, finding)
+			if err != nil {
+				return err
+			}
+
+			switch finding.Kind {
+			case fuzzer.NewCode:
+				fmt.Fprintf(os.Stderr, "%d bytes of code covered, saved %s\n", f.Covered(), saved)
+			case fuzzer.Error:
+				fmt.Printf("Found %s: %x %q\n  %v\n", finding.Kind, finding.Input, finding.Input, finding.Err)
+			default:
+				fmt.Printf("Found %s: %x %q\n", finding.Kind, finding.Input, finding.Input)
+			}
+			if finding.Kind != fuzzer.NewCode {
+				fmt.Printf("  replay with: slede8dbg debug --input-file %s %s\n", saved, path)
+				found = true
+			}
+		}
+		return nil
+	}
+
+	for _, seed := range seeds {
+		if err := handle(f.Add(seed)); err != nil {
+			return err
+		} else if found {
+			break
+		}
+	}
+
+	deadline := time.Now().Add(opts.duration)
+	for !found && (opts.runs == 0 || f.Runs < opts.runs) && time.Now().Before(deadline) {
+		if err := handle(f.Next()); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%d runs, %d bytes of code covered, %d inputs in the corpus\n",
+		f.Runs, f.Covered(), len(f.Corpus()))
+
+	if !found && (target >= 0 || len(want) > 0 || opts.errors) {
+		return cli.NewExitError("Nothing found", 1)
+	}
+	return nil
+}
+
+// parseAddress is a label or a number (0x1f, 31).
+func parseAddress(s string, labels map[string]uint16) (uint16, error) {
+	if addr, found := labels[s]; found {
+		return addr, nil
+	}
+	addr, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || addr >= vm.MemSize {
+		return 0, errors.Errorf("Bad address: %s", s)
+	}
+	return uint16(addr), nil
+}
+
+// loadCorpus reads the inputs (hex, .hex files) in dir, creating it if
+// needed.
+func loadCorpus(dir string) ([][]byte, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.hex"))
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs [][]byte
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		input, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Bad corpus file %s", path)
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
+
+// saveFinding writes the input to <kind>-<hash>.hex in dir.
+func saveFinding(dir string, finding fuzzer.Finding) (string, error) {
+	sum := sha1.Sum(finding.Input)
+	path := filepath.Join(dir, fmt.Sprintf("%s-%x.hex", finding.Kind, sum[:6]))
+	return path, ioutil.WriteFile(path, []byte(hex.EncodeToString(finding.Input)+"\n"), 0644)
+}
+
+type solveOptions struct {
+	cycleLimit int
+	target     string // label or address
+	output     string
+	outputHex  string
+	maxInput   int
+	maxPaths   int
+	showPath   bool
+}
+
+// solve explores the program symbolically for input reaching the target
+// or printing the output.
+func solve(path string, opts solveOptions) error {
+	binary, listing, err := loadProgram(path)
+	if err != nil {
+		return err
+	}
+
+	target := -1
+	if opts.target != "" {
+		addr, err := parseAddress(opts.target, listing.Labels)
+		if err != nil {
+			return err
+		}
+		target = int(addr)
+	}
+
+	want := []byte(opts.output)
+	if opts.outputHex != "" {
+		if want, err = hex.DecodeString(opts.outputHex); err != nil {
+			return err
+		}
+	}
+	if target < 0 && len(want) == 0 {
+		return cli.NewExitError("--target or --output is required", 1)
+	}
+
+	solution, stats, err := symbolic.Explore(binary, symbolic.Options{
+		Target:     target,
+		Output:     want,
+		CycleLimit: opts.cycleLimit,
+		MaxInput:   opts.maxInput,
+		MaxPaths:   opts.maxPaths,
+	})
+	fmt.Fprintf(os.Stderr, "%d paths, %d pruned, %d finished, %d unsolved branches, %d solutions rejected\n",
+		stats.Paths, stats.Pruned, stats.Finished, stats.Unknown, stats.Rejected)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	fmt.Printf("Found: %x %q\n", solution.Input, solution.Input)
+	if opts.showPath {
+		for _, c := range solution.Path {
+			fmt.Printf("  %s\n", c)
+		}
+	}
+	fmt.Printf("  replay with: slede8dbg debug --input %x %s\n", solution.Input, path)
+	return nil
+}
+
+type bruteforceOptions struct {
+	oracle     string
+	cycleLimit int
+	prefix     string
+	prefixHex  string
+	printable  bool
+	charset    string
+	length     int
+	pad        string
+	maxLen     int
+	target     string // label or address
+	output     string
+	outputHex  string
+	workers    int
+}
+
+// bruteforceInput reconstructs the input byte by byte, see package
+// bruteforce.
+func bruteforceInput(path string, opts bruteforceOptions) error {
+	binary, listing, err := loadProgram(path)
+	if err != nil {
+		return err
+	}
+
+	oracle, err := bruteforce.OracleByName(opts.oracle)
+	if err != nil {
+		return err
+	}
+
+	target := -1
+	if opts.target != "" {
+		addr, err := parseAddress(opts.target, listing.Labels)
+		if err != nil {
+			return err
+		}
+		target = int(addr)
+	}
+
+	want := []byte(opts.output)
+	if opts.outputHex != "" {
+		if want, err = hex.DecodeString(opts.outputHex); err != nil {
+			return err
+		}
+	}
+
+	prefix := []byte(opts.prefix)
+	if opts.prefixHex != "" {
+		if prefix, err = hex.DecodeString(opts.prefixHex); err != nil {
+			return err
+		}
+	}
+
+	charset := []byte(opts.charset)
+	if opts.printable {
+		charset = bruteforce.Printable
+	}
+
+	var pad byte
+	if opts.pad != "" {
+		data, err := console.ParseBytes(opts.pad)
+		if err != nil {
+			return err
+		} else if len(data) != 1 {
+			return errors.Errorf("Pad must be a single byte: %s", opts.pad)
+		}
+		pad = data[0]
+	}
+
+	start := time.Now()
+	result, err := bruteforce.Run(binary, bruteforce.Options{
+		Oracle:     oracle,
+		CycleLimit: opts.cycleLimit,
+		Prefix:     prefix,
+		Charset:    charset,
+		Length:     opts.length,
+		Pad:        pad,
+		MaxLen:     opts.maxLen,
+		Target:     target,
+		Output:     want,
+		Workers:    opts.workers,
+		Progress: func(step bruteforce.Step) {
+			ties := ""
+			if step.Ties > 0 {
+				ties = fmt.Sprintf(", %d ties", step.Ties)
+			}
+			fmt.Fprintf(os.Stderr, "%3d: %02x %q (%s %d%s)\n", step.Pos, step.Byte, step.Byte, oracle, step.Score, ties)
+		},
+	})
+	if result == nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d runs in %v\n", result.Runs, time.Since(start).Round(time.Millisecond))
 
-	bytecode, err := assembler.Assemble(string(source))
-	if err != nil {
-		return nil, err
+	goal := target >= 0 || len(want) > 0
+	switch {
+	case result.Found:
+		fmt.Printf("Found: %x %q\n", result.Input, result.Input)
+	case goal:
+		fmt.Printf("Not found (%v), got as far as: %x %q\n", err, result.Input, result.Input)
+	default:
+		fmt.Printf("Stopped (%v): %x %q\n", err, result.Input, result.Input)
 	}
+	fmt.Printf("  replay with: slede8dbg debug --input %x %s\n", result.Replay, path)
 
-	var binary bytes.Buffer
-	binary.Write([]byte(vm.SledeHeader))
-	binary.Write(bytecode)
+	if goal && !result.Found {
+		return cli.NewExitError("", 1)
+	}
+	return nil
+}
 
-	return binary.Bytes(), nil
+type batchOptions struct {
+	cycleLimit int
+	stackLimit int
+	workers    int
+	text       bool // inputs are text lines, not hex
 }
 
-func debug(path, inputStr string, cycleLimit int) error {
-	input, err := hex.DecodeString(inputStr)
+// batchRun runs the program on every line of inputPath ("-" for stdin),
+// printing the results as JSON lines.
+func batchRun(path, inputPath string, opts batchOptions) error {
+	binary, _, err := loadProgram(path)
 	if err != nil {
 		return err
 	}
+	image, err := vm.NewVM(binary, nil, opts.cycleLimit)
+	if err != nil {
+		return err
+	}
+	image.StackLimit = opts.stackLimit
 
-	var binary []byte
-
-	if filepath.Ext(path) == asmExtension {
-		if binary, err = compileAsmFile(path); err != nil {
+	in := os.Stdin
+	if inputPath != "-" {
+		if in, err = os.Open(inputPath); err != nil {
 			return err
 		}
-	} else if binary, err = ioutil.ReadFile(path); err != nil {
+		defer in.Close()
+	}
+
+	// Reading stops at the first bad line, after which the results so far
+	// are still printed. done stops it when batch.Run returns early.
+	inputs := make(chan []byte)
+	done := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(inputs)
+		send := func(input []byte) bool {
+			select {
+			case inputs <- input:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(nil, 1<<20)
+		for line := 1; scanner.Scan(); line++ {
+			input := []byte(scanner.Text())
+			if !opts.text {
+				decoded, err := hex.DecodeString(strings.TrimSpace(scanner.Text()))
+				if err != nil {
+					readErr <- errors.Wrapf(err, "Line %d", line)
+					return
+				}
+				input = decoded
+			}
+			if !send(input) {
+				readErr <- nil
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	encoder := json.NewEncoder(os.Stdout)
+	err = batch.Run(image, inputs, opts.workers, func(r *batch.Result) error {
+		return encoder.Encode(r)
+	})
+	close(done)
+	if err != nil {
 		return err
 	}
+	return <-readErr
+}
+
+type decompileOptions struct {
+	addresses bool
+	function  string // label or address inside it
+}
 
-	debugger, err := debugger.NewUI(binary, input, cycleLimit)
+// decompile prints the program as structured pseudocode.
+func decompile(path string, opts decompileOptions) error {
+	binary, listing, err := loadProgram(path)
 	if err != nil {
 		return err
 	}
+	if _, err := vm.NewVM(binary, nil, 0); err != nil {
+		return err
+	}
+
+	decompiled := decompiler.Decompile(binary[len(vm.SledeHeader):], listing.Labels)
+	if opts.function != "" {
+		addr, err := parseAddress(opts.function, listing.Labels)
+		if err != nil {
+			return err
+		}
+		f := decompiled.FunctionAt(addr)
+		if f == nil {
+			return errors.Errorf("No code reached from the entry point at %s", opts.function)
+		}
+		decompiled = &decompiler.Listing{Functions: []*decompiler.Function{f}}
+	}
 
-	return debugger.MainLoop()
+	if opts.addresses {
+		fmt.Print(decompiled.WithAddresses())
+	} else {
+		fmt.Print(decompiled)
+	}
+	return nil
+}
+
+// inputFlag is --input, or the hex read from --input-file.
+func inputFlag(c *cli.Context) (string, error) {
+	if path := c.String("input-file"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return c.String("input"), nil
 }
 
 func main() {
@@ -86,19 +878,136 @@ func main() {
 					Aliases: []string{"i"},
 					Usage:   "hexadecimal input string (AKA SLEDE8 føde), e.g. CD21",
 				},
+				&cli.StringFlag{
+					Name:  "input-file",
+					Usage: "read the hexadecimal input from a file, e.g. found by fuzz",
+				},
+				&cli.IntFlag{
+					Name:    "limit",
+					Aliases: []string{"l"},
+					Usage:   "cycle (step) limit",
+					Value:   defaultCycleLimit,
+				},
+				&cli.StringFlag{
+					Name:    "script",
+					Aliases: []string{"s"},
+					Usage:   "console commands to execute on start",
+				},
+				&cli.StringFlag{
+					Name:  "load-state",
+					Usage: "resume from a VM snapshot (see the console's savestate)",
+				},
+				&cli.IntFlag{
+					Name:  "stack-limit",
+					Usage: "maximum call (TUR) depth, 0 for unlimited",
+					Value: vm.NPSTStackLimit,
+				},
+				&cli.StringFlag{
+					Name:  "check",
+					Usage: "memory checks (uninitialized reads, running past the program, PC wraparound): warn or break",
+				},
+				&cli.BoolFlag{
+					Name:    "interactive",
+					Aliases: []string{"I"},
+					Usage:   "prompt for input when LES runs out of it (F7 toggles)",
+				},
+				&cli.BoolFlag{
+					Name:  "no-session",
+					Usage: "don't restore or save break/watchpoints, layout and input",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError(".s8 / .asm path is missing", 1)
+				}
+
+				input, err := inputFlag(c)
+				if err != nil {
+					return err
+				}
+
+				return debug(c.Args().First(), debugOptions{
+					input:            input,
+					cycleLimit:       c.Int("limit"),
+					script:           c.String("script"),
+					state:            c.String("load-state"),
+					noSession:        c.Bool("no-session"),
+					check:            c.String("check"),
+					stackLimit:       c.Int("stack-limit"),
+					stackLimitSet:    c.IsSet("stack-limit"),
+					interactiveInput: c.Bool("interactive"),
+				})
+			},
+		},
+		{
+			Name:      "run",
+			Aliases:   []string{"r"},
+			Usage:     "run a SLEDE8 binary without the UI",
+			UsageText: "slede8dbg run [options] <path to SLEDE8 binary / ASM source>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "input",
+					Aliases: []string{"i"},
+					Usage:   "hexadecimal input string (AKA SLEDE8 føde), e.g. CD21",
+				},
+				&cli.StringFlag{
+					Name:  "input-file",
+					Usage: "read the hexadecimal input from a file, e.g. found by fuzz",
+				},
 				&cli.IntFlag{
 					Name:    "limit",
 					Aliases: []string{"l"},
 					Usage:   "cycle (step) limit",
 					Value:   defaultCycleLimit,
 				},
+				&cli.StringFlag{
+					Name:    "script",
+					Aliases: []string{"s"},
+					Usage:   "console commands to execute instead of running to the end",
+				},
+				&cli.BoolFlag{
+					Name:    "hex",
+					Aliases: []string{"x"},
+					Usage:   "print output as hex",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the final state, output (hex) and any error as JSON instead of streaming the output",
+				},
+				&cli.IntFlag{
+					Name:  "stack-limit",
+					Usage: "maximum call (TUR) depth, 0 for unlimited",
+					Value: vm.NPSTStackLimit,
+				},
+				&cli.StringFlag{
+					Name:  "check",
+					Usage: "memory checks (uninitialized reads, running past the program, PC wraparound): warn or break",
+				},
+				&cli.BoolFlag{
+					Name:  "stdin",
+					Usage: "read input from stdin (after --input), e.g. piped from another process",
+				},
 			},
 			Action: func(c *cli.Context) error {
 				if c.NArg() == 0 {
 					return cli.NewExitError(".s8 / .asm path is missing", 1)
 				}
 
-				return debug(c.Args().First(), c.String("input"), c.Int("limit"))
+				input, err := inputFlag(c)
+				if err != nil {
+					return err
+				}
+
+				return run(c.Args().First(), runOptions{
+					input:      input,
+					cycleLimit: c.Int("limit"),
+					script:     c.String("script"),
+					hexOutput:  c.Bool("hex"),
+					jsonOutput: c.Bool("json"),
+					stdin:      c.Bool("stdin"),
+					check:      c.String("check"),
+					stackLimit: c.Int("stack-limit"),
+				})
 			},
 		},
 		{
@@ -112,17 +1021,350 @@ func main() {
 					Usage:   "output file path",
 					Value:   "a.s8",
 				},
+				&cli.BoolFlag{
+					Name:    "optimize",
+					Aliases: []string{"O"},
+					Usage:   "remove NOPEs, redundant SETTs, jumps to the next instruction, jump chains and dead code",
+				},
+				&cli.StringFlag{
+					Name:    "input",
+					Aliases: []string{"i"},
+					Usage:   "hexadecimal input to compare the cycles of the optimized program with",
+				},
+				&cli.IntFlag{
+					Name:    "limit",
+					Aliases: []string{"l"},
+					Usage:   "cycle (step) limit when comparing",
+					Value:   defaultCycleLimit,
+				},
 			},
 			Action: func(c *cli.Context) error {
 				if c.NArg() == 0 {
 					return cli.NewExitError("Source path is missing", 1)
 				}
 
-				if binary, err := compileAsmFile(c.Args().First()); err != nil {
-					return err
-				} else {
-					return ioutil.WriteFile(c.String("output"), binary, 0644)
+				return compile(c.Args().First(), compileOptions{
+					output:     c.String("output"),
+					optimize:   c.Bool("optimize"),
+					input:      c.String("input"),
+					cycleLimit: c.Int("limit"),
+				})
+			},
+		},
+		{
+			Name:      "statediff",
+			Usage:     "compare two VM snapshots",
+			UsageText: "slede8dbg statediff <state A> <state B>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 2 {
+					return cli.NewExitError("Two state paths are required", 1)
+				}
+
+				return statediff(c.Args().Get(0), c.Args().Get(1))
+			},
+		},
+		{
+			Name:      "fuzz",
+			Usage:     "search for inputs reaching an address, printing an output or failing",
+			UsageText: "slede8dbg fuzz [options] <path to SLEDE8 binary / ASM source>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "input",
+					Aliases: []string{"i"},
+					Usage:   "hexadecimal seed input",
+				},
+				&cli.IntFlag{
+					Name:    "limit",
+					Aliases: []string{"l"},
+					Usage:   "cycle (step) limit of each run",
+					Value:   defaultCycleLimit,
+				},
+				&cli.StringFlag{
+					Name:    "target",
+					Aliases: []string{"t"},
+					Usage:   "label or address to reach",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "text to find in the output",
+				},
+				&cli.StringFlag{
+					Name:  "output-hex",
+					Usage: "hexadecimal bytes to find in the output",
+				},
+				&cli.BoolFlag{
+					Name:    "errors",
+					Aliases: []string{"e"},
+					Usage:   "look for VM errors (other than running out of input)",
+				},
+				&cli.StringFlag{
+					Name:  "corpus",
+					Usage: "directory of saved inputs (default: <program>.corpus)",
+				},
+				&cli.IntFlag{
+					Name:  "runs",
+					Usage: "stop after this many runs, 0 for no limit",
+				},
+				&cli.DurationFlag{
+					Name:  "time",
+					Usage: "stop after this long",
+					Value: time.Minute,
+				},
+				&cli.IntFlag{
+					Name:  "max-len",
+					Usage: "longest input tried",
+					Value: 64,
+				},
+				&cli.Int64Flag{
+					Name:  "seed",
+					Usage: "random seed",
+					Value: 1,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError(".s8 / .asm path is missing", 1)
+				}
+
+				return fuzz(c.Args().First(), fuzzOptions{
+					input:      c.String("input"),
+					cycleLimit: c.Int("limit"),
+					target:     c.String("target"),
+					output:     c.String("output"),
+					outputHex:  c.String("output-hex"),
+					errors:     c.Bool("errors"),
+					corpus:     c.String("corpus"),
+					runs:       c.Int("runs"),
+					duration:   c.Duration("time"),
+					maxInput:   c.Int("max-len"),
+					seed:       c.Int64("seed"),
+				})
+			},
+		},
+		{
+			Name:      "solve",
+			Usage:     "find input reaching an address or printing an output by symbolic execution",
+			UsageText: "slede8dbg solve [options] <path to SLEDE8 binary / ASM source>",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:    "limit",
+					Aliases: []string{"l"},
+					Usage:   "cycle (step) limit of each path",
+					Value:   defaultCycleLimit,
+				},
+				&cli.StringFlag{
+					Name:    "target",
+					Aliases: []string{"t", "reach"},
+					Usage:   "label or address to reach",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "text to find in the output",
+				},
+				&cli.StringFlag{
+					Name:  "output-hex",
+					Usage: "hexadecimal bytes to find in the output",
+				},
+				&cli.IntFlag{
+					Name:  "max-len",
+					Usage: "most input bytes read on a path",
+					Value: 64,
+				},
+				&cli.IntFlag{
+					Name:  "max-paths",
+					Usage: "paths explored before giving up",
+					Value: 10000,
+				},
+				&cli.BoolFlag{
+					Name:    "path",
+					Aliases: []string{"p"},
+					Usage:   "print the conditions on the input",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError(".s8 / .asm path is missing", 1)
+				}
+
+				return solve(c.Args().First(), solveOptions{
+					cycleLimit: c.Int("limit"),
+					target:     c.String("target"),
+					output:     c.String("output"),
+					outputHex:  c.String("output-hex"),
+					maxInput:   c.Int("max-len"),
+					maxPaths:   c.Int("max-paths"),
+					showPath:   c.Bool("path"),
+				})
+			},
+		},
+		{
+			Name:      "bruteforce",
+			Usage:     "reconstruct input byte by byte using a side channel (output, branches or cycles)",
+			UsageText: "slede8dbg bruteforce [options] <path to SLEDE8 binary / ASM source>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "oracle",
+					Usage: "side channel: output (length, or prefix of --output found), branches (BHOPPs executed) or cycles",
+					Value: "cycles",
+				},
+				&cli.IntFlag{
+					Name:    "limit",
+					Aliases: []string{"l"},
+					Usage:   "cycle (step) limit of each run",
+					Value:   defaultCycleLimit,
+				},
+				&cli.StringFlag{
+					Name:  "prefix",
+					Usage: "known start of the input",
+				},
+				&cli.StringFlag{
+					Name:  "prefix-hex",
+					Usage: "known start of the input, hexadecimal",
+				},
+				&cli.BoolFlag{
+					Name:    "printable",
+					Aliases: []string{"p"},
+					Usage:   "only try printable ASCII",
+				},
+				&cli.StringFlag{
+					Name:  "charset",
+					Usage: "characters to try, e.g. 0123456789abcdef (default: all bytes)",
+				},
+				&cli.IntFlag{
+					Name:  "length",
+					Usage: "pad the input to this length, for programs reading it all before checking",
+				},
+				&cli.StringFlag{
+					Name:  "pad",
+					Usage: "padding byte, hex or .DATA syntax, e.g. 'A' (default: 00)",
+				},
+				&cli.IntFlag{
+					Name:  "max-len",
+					Usage: "longest input tried",
+					Value: 64,
+				},
+				&cli.StringFlag{
+					Name:    "target",
+					Aliases: []string{"t"},
+					Usage:   "label or address to reach, stops when reached",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "text to find in the output, stops when found",
+				},
+				&cli.StringFlag{
+					Name:  "output-hex",
+					Usage: "hexadecimal bytes to find in the output",
+				},
+				&cli.IntFlag{
+					Name:        "workers",
+					Aliases:     []string{"j"},
+					Usage:       "parallel runs",
+					DefaultText: "number of CPUs",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError(".s8 / .asm path is missing", 1)
+				}
+
+				return bruteforceInput(c.Args().First(), bruteforceOptions{
+					oracle:     c.String("oracle"),
+					cycleLimit: c.Int("limit"),
+					prefix:     c.String("prefix"),
+					prefixHex:  c.String("prefix-hex"),
+					printable:  c.Bool("printable"),
+					charset:    c.String("charset"),
+					length:     c.Int("length"),
+					pad:        c.String("pad"),
+					maxLen:     c.Int("max-len"),
+					target:     c.String("target"),
+					output:     c.String("output"),
+					outputHex:  c.String("output-hex"),
+					workers:    c.Int("workers"),
+				})
+			},
+		},
+		{
+			Name:      "batch",
+			Usage:     "run a SLEDE8 binary on many inputs in parallel, printing JSON lines",
+			UsageText: "slede8dbg batch [options] <path to SLEDE8 binary / ASM source> [<inputs file, one hex input per line>]",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:    "limit",
+					Aliases: []string{"l"},
+					Usage:   "cycle (step) limit of each run",
+					Value:   defaultCycleLimit,
+				},
+				&cli.IntFlag{
+					Name:  "stack-limit",
+					Usage: "maximum call (TUR) depth, 0 for unlimited",
+					Value: vm.NPSTStackLimit,
+				},
+				&cli.IntFlag{
+					Name:        "workers",
+					Aliases:     []string{"j"},
+					Usage:       "parallel runs",
+					DefaultText: "number of CPUs",
+				},
+				&cli.BoolFlag{
+					Name:  "text",
+					Usage: "the lines are text instead of hex",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError(".s8 / .asm path is missing", 1)
+				}
+
+				inputPath := "-"
+				if c.NArg() > 1 {
+					inputPath = c.Args().Get(1)
+				}
+
+				return batchRun(c.Args().First(), inputPath, batchOptions{
+					cycleLimit: c.Int("limit"),
+					stackLimit: c.Int("stack-limit"),
+					workers:    c.Int("workers"),
+					text:       c.Bool("text"),
+				})
+			},
+		},
+		{
+			Name:      "decompile",
+			Usage:     "print a SLEDE8 binary as structured pseudocode",
+			UsageText: "slede8dbg decompile [options] <path to SLEDE8 binary / ASM source>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "addresses",
+					Aliases: []string{"a"},
+					Usage:   "prefix lines with the address of their code",
+				},
+				&cli.StringFlag{
+					Name:    "function",
+					Aliases: []string{"f"},
+					Usage:   "only the function containing this label or address",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError(".s8 / .asm path is missing", 1)
 				}
+
+				return decompile(c.Args().First(), decompileOptions{
+					addresses: c.Bool("addresses"),
+					function:  c.String("function"),
+				})
+			},
+		},
+		{
+			Name:  "lsp",
+			Usage: "run a Language Server Protocol server for SLEDE8 asm (stdio)",
+			Action: func(c *cli.Context) error {
+				return lsp.NewServer(os.Stdin, os.Stdout).Serve()
 			},
 		},
 	}
@@ -133,16 +1375,11 @@ func main() {
 			cli.ShowAppHelpAndExit(c, 0)
 		}
 
-		var err error
-		cycleLimit := defaultCycleLimit
-
-		if c.NArg() > 2 {
-			if cycleLimit, err = strconv.Atoi(c.Args().Get(2)); err != nil {
-				return err
-			}
+		opts, err := legacyDebugOptions(c.Args().Tail())
+		if err != nil {
+			return err
 		}
-
-		return debug(c.Args().Get(0), c.Args().Get(1), cycleLimit)
+		return debug(c.Args().First(), opts)
 	}
 
 	if err := app.Run(os.Args); err != nil {
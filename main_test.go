@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+func TestDebugStackLimit(t *testing.T) {
+	program := []byte(vm.SledeHeader)
+
+	// slede8dbg <path> [<input> [cycle limit]]
+	opts, err := legacyDebugOptions([]string{"41", "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := vm.NewVM(program, nil, opts.cycleLimit)
+	if err := opts.configure(m); err != nil {
+		t.Fatal(err)
+	}
+	if opts.input != "41" || m.CycleLimit != 100 || m.StackLimit != vm.NPSTStackLimit {
+		t.Errorf("Unexpected input %q, cycle limit %d, stack limit %d", opts.input, m.CycleLimit, m.StackLimit)
+	}
+
+	// A loaded state keeps its limit unless --stack-limit is given
+	state, _ := vm.NewVM(program, nil, 100)
+	state.StackLimit = 7
+	opts = debugOptions{state: "state.json", stackLimit: vm.NPSTStackLimit}
+	if opts.configure(state); state.StackLimit != 7 {
+		t.Errorf("Expected the state's stack limit 7, got %d", state.StackLimit)
+	}
+	opts.stackLimit, opts.stackLimitSet = 0, true
+	if opts.configure(state); state.StackLimit != 0 {
+		t.Errorf("Expected --stack-limit 0 to win, got %d", state.StackLimit)
+	}
+}
@@ -0,0 +1,186 @@
+package reference
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// traceLength is how many instructions a Divergence shows.
+const traceLength = 16
+
+// Divergence is the first difference found between vm.VM and the
+// reference.
+type Divergence struct {
+	Cycle int      // instructions both executed before diverging
+	Diffs []string // e.g. "r3: vm 0x01, reference 0x02"
+	Trace []string // the last instructions, ending with the diverging one
+}
+
+func (d *Divergence) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Diverged at cycle %d:\n", d.Cycle)
+	for _, diff := range d.Diffs {
+		fmt.Fprintf(&b, "  %s\n", diff)
+	}
+	if len(d.Trace) > 0 {
+		b.WriteString("Trace (reference):\n")
+		for _, line := range d.Trace {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	return b.String()
+}
+
+// Lockstep runs program on a vm.VM and on the reference one instruction
+// at a time and returns the first divergence, nil if they agree to the
+// end. limit should be set, the programs may not stop.
+func Lockstep(program, input []byte, limit int) (*Divergence, error) {
+	m, ref, err := load(program, input, limit)
+	if err != nil {
+		return nil, err
+	}
+	return lockstep(m, ref), nil
+}
+
+// CompareRun runs the program to the end with VM.RunFast and the
+// reference and compares the results.
+func CompareRun(program, input []byte, limit int) (*Divergence, error) {
+	m, ref, err := load(program, input, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	m.RunFast()
+	ref.Run()
+	if diffs := compare(m, ref); len(diffs) > 0 {
+		return &Divergence{Cycle: ref.Cycles, Diffs: diffs}, nil
+	}
+	return nil, nil
+}
+
+func load(program, input []byte, limit int) (*vm.VM, *Machine, error) {
+	m, err := vm.NewVM(program, append([]byte{}, input...), limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref, err := New(program, append([]byte{}, input...), limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, ref, nil
+}
+
+func lockstep(m *vm.VM, ref *Machine) *Divergence {
+	var trace []string
+	for {
+		before := *ref
+		m.Step()
+		ref.Step()
+
+		trace = append(trace, describeStep(&before, ref))
+		if len(trace) > traceLength {
+			trace = trace[1:]
+		}
+
+		if diffs := compare(m, ref); len(diffs) > 0 {
+			return &Divergence{Cycle: before.Cycles, Diffs: diffs, Trace: trace}
+		}
+		if m.State != vm.Running {
+			return nil
+		}
+	}
+}
+
+// describeStep disassembles the instruction executed by the reference
+// and lists its effects.
+func describeStep(before, after *Machine) string {
+	word := uint16(before.Mem[before.PC%memSize]) | uint16(before.Mem[(before.PC+1)%memSize])<<8
+	line := fmt.Sprintf("%5d 0x%03x: %-20s", before.Cycles, before.PC, vm.ParseInstruction(word))
+
+	var effects []string
+	for i := range after.Regs {
+		if after.Regs[i] != before.Regs[i] {
+			effects = append(effects, fmt.Sprintf("r%d=0x%02x", i, after.Regs[i]))
+		}
+	}
+	if after.Flag != before.Flag {
+		effects = append(effects, fmt.Sprintf("flag=%t", after.Flag))
+	}
+	if addr := firstDifference(before.Mem[:], after.Mem[:]); addr >= 0 {
+		effects = append(effects, fmt.Sprintf("mem[0x%03x]=0x%02x", addr, after.Mem[addr]))
+	}
+	if len(after.Output) > len(before.Output) {
+		effects = append(effects, fmt.Sprintf("output 0x%02x", after.Output[len(after.Output)-1]))
+	}
+	if after.Err != "" {
+		effects = append(effects, after.Err)
+	}
+	return strings.TrimRight(line+" "+strings.Join(effects, " "), " ")
+}
+
+func compare(m *vm.VM, ref *Machine) []string {
+	var diffs []string
+	add := func(name string, actual, expected interface{}) {
+		diffs = append(diffs, fmt.Sprintf("%s: vm %v, reference %v", name, actual, expected))
+	}
+
+	if state, refState := vmState(m), refState(ref); state != refState {
+		add("state", state, refState)
+	}
+	if m.PC != ref.PC {
+		add("pc", fmt.Sprintf("0x%03x", m.PC), fmt.Sprintf("0x%03x", ref.PC))
+	}
+	if m.Flag != ref.Flag {
+		add("flag", m.Flag, ref.Flag)
+	}
+	for i := range m.Regs {
+		if m.Regs[i] != ref.Regs[i] {
+			add(fmt.Sprintf("r%d", i), fmt.Sprintf("0x%02x", m.Regs[i]), fmt.Sprintf("0x%02x", ref.Regs[i]))
+		}
+	}
+	if addr := firstDifference(m.Mem[:], ref.Mem[:]); addr >= 0 {
+		add(fmt.Sprintf("mem[0x%03x]", addr), fmt.Sprintf("0x%02x", m.Mem[addr]), fmt.Sprintf("0x%02x", ref.Mem[addr]))
+	}
+	if fmt.Sprint(m.Stack) != fmt.Sprint(ref.Stack) {
+		add("stack", fmt.Sprintf("%03x", m.Stack), fmt.Sprintf("%03x", ref.Stack))
+	}
+	if m.InputIndex != ref.InputPos {
+		add("input index", m.InputIndex, ref.InputPos)
+	}
+	if !bytes.Equal(m.Output, ref.Output) {
+		add("output", fmt.Sprintf("%x", m.Output), fmt.Sprintf("%x", ref.Output))
+	}
+	if m.CycleCount != ref.Cycles {
+		add("cycles", m.CycleCount, ref.Cycles)
+	}
+	return diffs
+}
+
+func vmState(m *vm.VM) string {
+	if m.State == vm.Error {
+		return "error " + vm.ErrorKindOf(m.LastError).String()
+	}
+	return m.State.String()
+}
+
+func refState(m *Machine) string {
+	switch {
+	case m.Err != "":
+		return "error " + m.Err
+	case m.Halted:
+		return "stopped"
+	}
+	return "running"
+}
+
+func firstDifference(a, b []byte) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
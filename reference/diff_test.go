@@ -0,0 +1,131 @@
+package reference
+
+import (
+	"flag"
+	"math/rand"
+	"testing"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+var (
+	seed     = flag.Int64("seed", 1, "seed for the random programs")
+	programs = flag.Int("programs", 3000, "number of random programs to compare")
+)
+
+// randomProgram is mostly valid instructions on a few registers, so they
+// interact, with jumps and calls into the program and the odd random word.
+func randomProgram(r *rand.Rand) []byte {
+	reg := func() int {
+		if r.Intn(4) == 0 {
+			return r.Intn(16)
+		}
+		return r.Intn(4)
+	}
+
+	n := 1 + r.Intn(32)
+	program := []byte(header)
+	for i := 0; i < n; i++ {
+		class := 1 + r.Intn(12)
+		var w int
+		switch {
+		case r.Intn(8) == 0:
+			w = r.Intn(0x10000)
+		case class == 0x1:
+			w = class | reg()<<4 | r.Intn(256)<<8
+		case class == 0x2:
+			w = class | reg()<<4 | reg()<<8
+		case class == 0x4 || class == 0x6:
+			w = class | r.Intn(2)<<4 | reg()<<8
+		case class == 0x5:
+			w = class | r.Intn(7)<<4 | reg()<<8 | reg()<<12
+		case class == 0x7:
+			w = class | r.Intn(6)<<4 | reg()<<8 | reg()<<12
+		case class >= 0x8 && class <= 0xa && r.Intn(4) != 0:
+			w = class | r.Intn(2*n)<<4
+		default:
+			w = class | r.Intn(0x1000)<<4
+		}
+		program = append(program, byte(w), byte(w>>8))
+	}
+	return program
+}
+
+func randomInput(r *rand.Rand) []byte {
+	input := make([]byte, r.Intn(8))
+	r.Read(input)
+	return input
+}
+
+func TestLockstep(t *testing.T) {
+	n := *programs
+	if testing.Short() {
+		n /= 10
+	}
+
+	r := rand.New(rand.NewSource(*seed))
+	for i := 0; i < n; i++ {
+		program, input := randomProgram(r), randomInput(r)
+
+		d, err := Lockstep(program, input, 500)
+		if err != nil {
+			t.Fatal(err)
+		} else if d != nil {
+			t.Fatalf("Program %x, input %x\n%s", program, input, d)
+		}
+
+		if d, err := CompareRun(program, input, 500); err != nil {
+			t.Fatal(err)
+		} else if d != nil {
+			t.Fatalf("RunFast: program %x, input %x\n%s", program, input, d)
+		}
+	}
+}
+
+func TestReturnAddressWraps(t *testing.T) {
+	// TUR in the last word returns to 0
+	program := []byte(header + "\x01\x05" + "\xe8\xff" + "\x0b\x00")
+	m, ref, err := load(program, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Mem[0xffe], m.Mem[0xfff] = 0x4a, 0x00
+	ref.Mem[0xffe], ref.Mem[0xfff] = 0x4a, 0x00
+
+	if d := lockstep(m, ref); d != nil {
+		t.Errorf("Unexpected divergence\n%s", d)
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	program := []byte(header + "\x0c\x00" + "\x0c\x00" + "\x06\x00" + "\x00\x00")
+	m, ref, err := load(program, []byte{0x41}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref.Input = []byte{0x42}
+
+	d := lockstep(m, ref)
+	if d == nil {
+		t.Fatal("Expected a divergence")
+	}
+
+	expected := "Diverged at cycle 2:\n" +
+		"  r0: vm 0x41, reference 0x42\n" +
+		"Trace (reference):\n" +
+		"      0 0x000: NOPE\n" +
+		"      1 0x002: NOPE\n" +
+		"      2 0x004: LES r0               r0=0x42\n"
+	if actual := d.String(); actual != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New([]byte("SLEDE8"), nil, 0); err == nil {
+		t.Error("Expected a header error")
+	}
+	if _, err := New(append([]byte(header), make([]byte, vm.MemSize+1)...), nil, 0); err == nil {
+		t.Error("Expected a size error")
+	}
+}
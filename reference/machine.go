@@ -0,0 +1,218 @@
+// Package reference is a deliberately simple model of the SLEDE8
+// semantics, written independently of the vm package so the two can be
+// checked against each other (see Lockstep). It favours being obviously
+// right over being fast and has none of the debugger features.
+package reference
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	header  = ".SLEDE8"
+	memSize = 4096
+
+	// Return addresses the NPST runtime has room for, a TUR beyond them
+	// overflows
+	stackSize = 254
+)
+
+// Error names, the same as vm.ErrorKind.String().
+const (
+	ErrNoMoreInput    = "no_more_input"
+	ErrEmptyStack     = "empty_stack"
+	ErrStackOverflow  = "stack_overflow"
+	ErrCycleLimit     = "cycle_limit"
+	ErrBadInstruction = "bad_instruction"
+)
+
+// Machine is the whole state of a SLEDE8 program.
+type Machine struct {
+	PC    uint16
+	Flag  bool
+	Regs  [16]byte
+	Mem   [memSize]byte
+	Stack []uint16
+
+	Input    []byte
+	InputPos int
+	Output   []byte
+
+	Cycles int
+	Limit  int // 0: unlimited
+
+	Halted bool
+	Err    string // one of the Err names once failed
+}
+
+// New loads a program (with the .SLEDE8 header) at address 0.
+func New(program, input []byte, limit int) (*Machine, error) {
+	if !bytes.HasPrefix(program, []byte(header)) {
+		return nil, errors.Errorf("Expected %s header", header)
+	}
+	code := program[len(header):]
+	if len(code) > memSize {
+		return nil, errors.Errorf("Program size (%d) exceeds memory limit (%d)", len(code), memSize)
+	}
+
+	m := &Machine{Input: input, Limit: limit}
+	copy(m.Mem[:], code)
+	return m, nil
+}
+
+// Running is false once the program has stopped or failed.
+func (m *Machine) Running() bool {
+	return !m.Halted && m.Err == ""
+}
+
+// Step executes one instruction. Instructions are two bytes, little
+// endian, made of four nibbles: the class (lowest), then a, b and c.
+func (m *Machine) Step() {
+	if !m.Running() {
+		return
+	}
+	if m.Limit > 0 && m.Cycles >= m.Limit {
+		m.Err = ErrCycleLimit
+		return
+	}
+
+	word := int(m.Mem[m.PC%memSize]) | int(m.Mem[(m.PC+1)%memSize])<<8
+	class := word & 0xf
+	a := (word >> 4) & 0xf
+	b := (word >> 8) & 0xf
+	c := (word >> 12) & 0xf
+	address := uint16(word >> 4) // 12 bits
+	value := byte(word >> 8)
+
+	next := (m.PC + 2) % memSize
+
+	switch class {
+	case 0x0: // STOPP
+		m.Halted = true
+		return
+
+	case 0x1: // SETT reg, value
+		m.Regs[a] = value
+
+	case 0x2: // SETT reg, reg
+		m.Regs[a] = m.Regs[b]
+
+	case 0x3: // FINN
+		m.Regs[0] = byte(address & 0xff)
+		m.Regs[1] = byte(address >> 8)
+
+	case 0x4: // LAST / LAGR at r1:r0
+		at := (int(m.Regs[1])*256 + int(m.Regs[0])) % memSize
+		switch a {
+		case 0:
+			m.Regs[b] = m.Mem[at]
+		case 1:
+			m.Mem[at] = m.Regs[b]
+		default:
+			m.Err = ErrBadInstruction
+			return
+		}
+
+	case 0x5: // ALU
+		x, y := int(m.Regs[b]), int(m.Regs[c])
+		var result int
+		switch a {
+		case 0:
+			result = x & y
+		case 1:
+			result = x | y
+		case 2:
+			result = x ^ y
+		case 3:
+			result = x << uint(y)
+		case 4:
+			result = x >> uint(y)
+		case 5:
+			result = x + y
+		case 6:
+			result = x - y
+		default:
+			m.Err = ErrBadInstruction
+			return
+		}
+		m.Regs[b] = byte(result & 0xff)
+
+	case 0x6: // LES / SKRIV
+		switch a {
+		case 0:
+			if m.InputPos >= len(m.Input) {
+				m.Err = ErrNoMoreInput
+				return
+			}
+			m.Regs[b] = m.Input[m.InputPos]
+			m.InputPos++
+		case 1:
+			m.Output = append(m.Output, m.Regs[b])
+		default:
+			m.Err = ErrBadInstruction
+			return
+		}
+
+	case 0x7: // comparisons
+		x, y := m.Regs[b], m.Regs[c]
+		switch a {
+		case 0:
+			m.Flag = x == y
+		case 1:
+			m.Flag = x != y
+		case 2:
+			m.Flag = x < y
+		case 3:
+			m.Flag = x <= y
+		case 4:
+			m.Flag = x > y
+		case 5:
+			m.Flag = x >= y
+		default:
+			m.Err = ErrBadInstruction
+			return
+		}
+
+	case 0x8: // HOPP
+		next = address
+
+	case 0x9: // BHOPP
+		if m.Flag {
+			next = address
+		}
+
+	case 0xa: // TUR
+		if len(m.Stack) == stackSize {
+			m.Err = ErrStackOverflow
+			return
+		}
+		m.Stack = append(m.Stack, next)
+		next = address
+
+	case 0xb: // RETUR
+		if len(m.Stack) == 0 {
+			m.Err = ErrEmptyStack
+			return
+		}
+		next = m.Stack[len(m.Stack)-1]
+		m.Stack = m.Stack[:len(m.Stack)-1]
+
+	case 0xc: // NOPE
+
+	default:
+		m.Err = ErrBadInstruction
+		return
+	}
+
+	m.PC = next
+	m.Cycles++
+}
+
+// Run steps until the program stops or fails.
+func (m *Machine) Run() {
+	for m.Running() {
+		m.Step()
+	}
+}
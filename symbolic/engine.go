@@ -0,0 +1,352 @@
+// Package symbolic executes SLEDE8 programs with symbolic input: bytes
+// read by LES are unknowns, BHOPP on a flag depending on them explores
+// both branches, and the conditions of a path are solved (by a small
+// built-in solver) to find concrete input reaching an address or
+// printing a given output.
+package symbolic
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// Options for Explore. One of Target and Output should be set.
+type Options struct {
+	Target     int    // address to reach, -1 for none
+	Output     []byte // output to produce (anywhere in the output)
+	CycleLimit int    // per path
+	MaxInput   int    // most input bytes read on a path
+	MaxPaths   int    // paths explored before giving up
+}
+
+// Solution is input reaching the goal.
+type Solution struct {
+	Input []byte
+	Path  []*Cond // the conditions the input satisfies
+}
+
+// Stats describe the search.
+type Stats struct {
+	Paths    int // started, including the first
+	Pruned   int // branches found impossible
+	Unknown  int // branches the solver gave up on (explored anyway)
+	Finished int // paths which stopped, failed or hit a limit
+	Rejected int // solutions the VM didn't confirm, see confirmed
+}
+
+var (
+	ErrNotFound   = errors.New("No path reaches the goal")
+	ErrPathLimit  = errors.New("Path limit reached")
+	ErrInputLimit = errors.New("Input limit reached")
+)
+
+// state is one path through the program.
+type state struct {
+	pc    uint16
+	regs  [vm.RegCount]*Expr
+	flag  *Cond // nil: false
+	stack []uint16
+
+	program *[vm.MemSize]byte
+	written map[uint16]*Expr // by LAGR, copied on fork
+
+	inputs int // bytes read
+	output []*Expr
+	cycles int
+
+	path  []*Cond
+	model []byte // satisfies path, one value per input byte read
+}
+
+func (s *state) fork() *state {
+	child := *s
+	child.stack = append([]uint16{}, s.stack...)
+	child.written = make(map[uint16]*Expr, len(s.written))
+	for addr, e := range s.written {
+		child.written[addr] = e
+	}
+	child.output = append([]*Expr{}, s.output...)
+	child.path = append([]*Cond{}, s.path...)
+	child.model = append([]byte{}, s.model...)
+	return &child
+}
+
+// assume adds c to the path, false if it's impossible.
+func (s *state) assume(c *Cond, stats *Stats) bool {
+	if c.IsConst() {
+		return c.Holds(nil)
+	}
+
+	s.path = append(s.path, c)
+	if c.Holds(s.model) {
+		return true
+	}
+
+	switch Solve(s.path, s.model) {
+	case Sat:
+		return true
+	case Unknown:
+		stats.Unknown++
+		return true
+	}
+	stats.Pruned++
+	return false
+}
+
+func (s *state) load(addr uint16) *Expr {
+	if e, found := s.written[addr]; found {
+		return e
+	}
+	return Const(s.program[addr])
+}
+
+// concrete picks a value for e, the one of the current model, and sticks
+// to it (the path gets e == value).
+func (s *state) concrete(e *Expr) byte {
+	if e.IsConst() {
+		return e.Value
+	}
+	value := e.Eval(s.model)
+	s.path = append(s.path, NewCond(Eq, e, Const(value)))
+	return value
+}
+
+// Explore searches the paths of program (with the .SLEDE8 header),
+// breadth first, for input reaching the goal.
+func Explore(program []byte, opts Options) (*Solution, Stats, error) {
+	var stats Stats
+
+	m, err := vm.NewVM(program, nil, 0)
+	if err != nil {
+		return nil, stats, err
+	}
+	if opts.MaxInput <= 0 {
+		opts.MaxInput = 64
+	}
+	if opts.MaxPaths <= 0 {
+		opts.MaxPaths = 10000
+	}
+
+	initial := &state{program: &m.Mem, written: map[uint16]*Expr{}}
+	for i := range initial.regs {
+		initial.regs[i] = Const(0)
+	}
+
+	queue := []*state{initial}
+	stats.Paths = 1
+	hitInputLimit := false
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		for {
+			if int(s.pc) == opts.Target {
+				if confirmed(program, s, opts) {
+					return &Solution{Input: s.model, Path: s.path}, stats, nil
+				}
+				stats.Rejected++
+				stats.Finished++
+				break
+			}
+			if opts.CycleLimit > 0 && s.cycles >= opts.CycleLimit {
+				stats.Finished++
+				break
+			}
+
+			outputs := len(s.output)
+			other, running := step(s, &stats)
+			if other != nil {
+				if stats.Paths >= opts.MaxPaths {
+					return nil, stats, ErrPathLimit
+				}
+				stats.Paths++
+				queue = append(queue, other)
+			}
+
+			if len(s.output) > outputs && s.producedOutput(opts.Output) {
+				if confirmed(program, s, opts) {
+					return &Solution{Input: s.model, Path: s.path}, stats, nil
+				}
+				stats.Rejected++
+				stats.Finished++
+				break
+			}
+			if !running || s.inputs > opts.MaxInput {
+				if s.inputs > opts.MaxInput {
+					hitInputLimit = true
+				}
+				stats.Finished++
+				break
+			}
+		}
+	}
+
+	if hitInputLimit {
+		return nil, stats, ErrInputLimit
+	}
+	return nil, stats, ErrNotFound
+}
+
+// confirmed replays the input of s on a vm.VM, which must reach the goal
+// within the cycles of the path too. Paths the solver gave up on are
+// explored as if possible, so their input may well not get there.
+func confirmed(program []byte, s *state, opts Options) bool {
+	m, err := vm.NewVM(program, append([]byte{}, s.model...), s.cycles+1)
+	if err != nil {
+		return false
+	}
+	reached := func() bool {
+		return int(m.PC) == opts.Target || len(opts.Output) > 0 && bytes.Contains(m.Output, opts.Output)
+	}
+	for m.State == vm.Running && !reached() {
+		m.Step()
+	}
+	return reached()
+}
+
+// producedOutput checks whether the last output byte can complete want,
+// adding the conditions for it to the path if so.
+func (s *state) producedOutput(want []byte) bool {
+	start := len(s.output) - len(want)
+	if len(want) == 0 || start < 0 {
+		return false
+	}
+
+	path := append([]*Cond{}, s.path...)
+	for i, b := range want {
+		c := NewCond(Eq, s.output[start+i], Const(b))
+		if c.IsConst() {
+			if !c.Holds(nil) {
+				return false
+			}
+			continue
+		}
+		path = append(path, c)
+	}
+
+	model := append([]byte{}, s.model...)
+	if Solve(path, model) != Sat {
+		return false
+	}
+	s.path, s.model = path, model
+	return true
+}
+
+// step executes the instruction at s.pc. When the flag of BHOPP depends
+// on the input, the taken branch continues in s and the other one is
+// returned (if possible). running is false once the path ends.
+func step(s *state, stats *Stats) (other *state, running bool) {
+	pc := s.pc % vm.MemSize
+	word := uint16(s.concrete(s.load(pc))) | uint16(s.concrete(s.load((pc+1)%vm.MemSize)))<<8
+	i := vm.ParseInstruction(word)
+
+	next := (pc + 2) % vm.MemSize
+	address := func() uint16 {
+		return (uint16(s.concrete(s.regs[0])) | uint16(s.concrete(s.regs[1]))<<8) % vm.MemSize
+	}
+
+	switch i.Class {
+	case vm.OpClassHalt:
+		return nil, false
+
+	case vm.OpClassMovImm:
+		s.regs[i.Op] = Const(i.Val)
+
+	case vm.OpClassMovReg:
+		s.regs[i.Op] = s.regs[i.Arg1]
+
+	case vm.OpClassFinn:
+		s.regs[0] = Const(byte(i.Addr))
+		s.regs[1] = Const(byte(i.Addr >> 8))
+
+	case vm.OpClassLoadStore:
+		switch i.Op {
+		case 0:
+			s.regs[i.Arg1] = s.load(address())
+		case 1:
+			s.written[address()] = s.regs[i.Arg1]
+		default:
+			return nil, false
+		}
+
+	case vm.OpClassALU:
+		if i.Op > 6 {
+			return nil, false
+		}
+		op := []Op{OpAnd, OpOr, OpXor, OpShl, OpShr, OpAdd, OpSub}[i.Op]
+		s.regs[i.Arg1] = Binary(op, s.regs[i.Arg1], s.regs[i.Arg2])
+
+	case vm.OpClassIO:
+		switch i.Op {
+		case 0:
+			s.regs[i.Arg1] = Input(s.inputs)
+			s.inputs++
+			s.model = append(s.model, valueOrder[0])
+		case 1:
+			s.output = append(s.output, s.regs[i.Arg1])
+		default:
+			return nil, false
+		}
+
+	case vm.OpClassCmp:
+		if i.Op > 5 {
+			return nil, false
+		}
+		s.flag = NewCond(CmpOp(i.Op), s.regs[i.Arg1], s.regs[i.Arg2])
+
+	case vm.OpClassJmp:
+		next = i.Addr
+
+	case vm.OpClassCondJmp:
+		if s.flag == nil {
+			break
+		} else if s.flag.IsConst() {
+			if s.flag.Holds(nil) {
+				next = i.Addr
+			}
+			break
+		}
+
+		// Fall through in a copy, jump in s
+		notTaken := s.fork()
+		notTaken.pc = next
+		notTaken.cycles++
+		if !notTaken.assume(s.flag.Not(), stats) {
+			notTaken = nil
+		}
+
+		if s.assume(s.flag, stats) {
+			next = i.Addr
+			other = notTaken
+		} else if notTaken != nil {
+			*s = *notTaken
+			return nil, true
+		} else {
+			return nil, false
+		}
+
+	case vm.OpClassCall:
+		s.stack = append(s.stack, next)
+		next = i.Addr
+
+	case vm.OpClassRet:
+		if len(s.stack) == 0 {
+			return nil, false
+		}
+		next = s.stack[len(s.stack)-1]
+		s.stack = s.stack[:len(s.stack)-1]
+
+	case vm.OpClassNop:
+
+	default:
+		return nil, false
+	}
+
+	s.pc = next
+	s.cycles++
+	return other, true
+}
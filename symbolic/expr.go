@@ -0,0 +1,232 @@
+package symbolic
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Op is an 8-bit operation, the ALU operations plus constants and input
+// bytes.
+type Op int
+
+const (
+	OpConst Op = iota
+	OpInput
+	OpAnd
+	OpOr
+	OpXor
+	OpShl
+	OpShr
+	OpAdd
+	OpSub
+)
+
+var opSymbols = map[Op]string{
+	OpAnd: "&", OpOr: "|", OpXor: "^", OpShl: "<<", OpShr: ">>", OpAdd: "+", OpSub: "-",
+}
+
+// Expr is a byte computed from the input. Expressions are immutable and
+// shared, so they form a DAG.
+type Expr struct {
+	Op    Op
+	Value byte // OpConst
+	Index int  // OpInput
+	A, B  *Expr
+
+	vars []int // sorted input indexes used
+}
+
+func Const(value byte) *Expr {
+	return &Expr{Op: OpConst, Value: value}
+}
+
+// Input is the index-th byte read by LES.
+func Input(index int) *Expr {
+	return &Expr{Op: OpInput, Index: index, vars: []int{index}}
+}
+
+// IsConst reports whether e is a known value.
+func (e *Expr) IsConst() bool {
+	return e.Op == OpConst
+}
+
+// Binary applies an ALU operation, folding constants and identities.
+func Binary(op Op, a, b *Expr) *Expr {
+	if a.IsConst() && b.IsConst() {
+		return Const(apply(op, a.Value, b.Value))
+	}
+
+	switch {
+	case b.IsConst() && b.Value == 0 && (op == OpOr || op == OpXor || op == OpAdd || op == OpSub || op == OpShl || op == OpShr):
+		return a
+	case a.IsConst() && a.Value == 0 && (op == OpOr || op == OpXor || op == OpAdd):
+		return b
+	case (a.IsConst() && a.Value == 0 || b.IsConst() && b.Value == 0) && op == OpAnd:
+		return Const(0)
+	case b.IsConst() && b.Value >= 8 && (op == OpShl || op == OpShr):
+		return Const(0)
+	case a == b && (op == OpXor || op == OpSub):
+		return Const(0)
+	case a == b && (op == OpAnd || op == OpOr):
+		return a
+	}
+
+	return &Expr{Op: op, A: a, B: b, vars: mergeVars(a.vars, b.vars)}
+}
+
+func apply(op Op, a, b byte) byte {
+	switch op {
+	case OpAnd:
+		return a & b
+	case OpOr:
+		return a | b
+	case OpXor:
+		return a ^ b
+	case OpShl:
+		return a << b
+	case OpShr:
+		return a >> b
+	case OpAdd:
+		return a + b
+	case OpSub:
+		return a - b
+	}
+	panic(fmt.Sprintf("Not a binary op: %d", op))
+}
+
+func mergeVars(a, b []int) []int {
+	if len(b) == 0 {
+		return a
+	} else if len(a) == 0 {
+		return b
+	}
+
+	seen := map[int]bool{}
+	var vars []int
+	for _, list := range [][]int{a, b} {
+		for _, v := range list {
+			if !seen[v] {
+				seen[v] = true
+				vars = append(vars, v)
+			}
+		}
+	}
+	sort.Ints(vars)
+	return vars
+}
+
+// evaluator computes expressions for the input values in model, shared
+// subexpressions once. The cache is its own, expressions aren't changed
+// by evaluating them.
+type evaluator struct {
+	model []byte
+	cache map[*Expr]byte
+}
+
+func newEvaluator(model []byte) *evaluator {
+	return &evaluator{model: model, cache: map[*Expr]byte{}}
+}
+
+// reset forgets the values computed, for when the model changed.
+func (ev *evaluator) reset() {
+	for e := range ev.cache {
+		delete(ev.cache, e)
+	}
+}
+
+func (ev *evaluator) eval(e *Expr) byte {
+	switch e.Op {
+	case OpConst:
+		return e.Value
+	case OpInput:
+		return ev.model[e.Index]
+	}
+
+	if value, found := ev.cache[e]; found {
+		return value
+	}
+	value := apply(e.Op, ev.eval(e.A), ev.eval(e.B))
+	ev.cache[e] = value
+	return value
+}
+
+func (ev *evaluator) holds(c *Cond) bool {
+	return compare(c.Op, ev.eval(c.A), ev.eval(c.B))
+}
+
+// Eval computes e for the given input.
+func (e *Expr) Eval(input []byte) byte {
+	return newEvaluator(input).eval(e)
+}
+
+func (e *Expr) String() string {
+	switch e.Op {
+	case OpConst:
+		return fmt.Sprintf("0x%02x", e.Value)
+	case OpInput:
+		return fmt.Sprintf("in[%d]", e.Index)
+	}
+	return fmt.Sprintf("(%s %s %s)", e.A, opSymbols[e.Op], e.B)
+}
+
+// CmpOp is a comparison of unsigned bytes, as done by LIK, ULIK etc.
+type CmpOp int
+
+const (
+	Eq CmpOp = iota
+	Ne
+	Lt
+	Le
+	Gt
+	Ge
+)
+
+var cmpSymbols = [...]string{"==", "!=", "<", "<=", ">", ">="}
+var negated = [...]CmpOp{Ne, Eq, Ge, Gt, Le, Lt}
+
+// Cond is a comparison which must hold on a path.
+type Cond struct {
+	Op   CmpOp
+	A, B *Expr
+
+	vars []int
+}
+
+func NewCond(op CmpOp, a, b *Expr) *Cond {
+	return &Cond{Op: op, A: a, B: b, vars: mergeVars(a.vars, b.vars)}
+}
+
+// Not is the opposite comparison.
+func (c *Cond) Not() *Cond {
+	return &Cond{Op: negated[c.Op], A: c.A, B: c.B, vars: c.vars}
+}
+
+// IsConst reports whether c doesn't depend on the input.
+func (c *Cond) IsConst() bool {
+	return len(c.vars) == 0
+}
+
+func compare(op CmpOp, a, b byte) bool {
+	switch op {
+	case Eq:
+		return a == b
+	case Ne:
+		return a != b
+	case Lt:
+		return a < b
+	case Le:
+		return a <= b
+	case Gt:
+		return a > b
+	}
+	return a >= b
+}
+
+// Holds reports whether c is true for the given input.
+func (c *Cond) Holds(input []byte) bool {
+	return newEvaluator(input).holds(c)
+}
+
+func (c *Cond) String() string {
+	return fmt.Sprintf("%s %s %s", c.A, cmpSymbols[c.Op], c.B)
+}
@@ -0,0 +1,206 @@
+package symbolic
+
+import "sort"
+
+// Result of Solve.
+type Result int
+
+const (
+	Sat Result = iota
+	Unsat
+	Unknown // gave up, see solveBudget
+)
+
+// solveBudget caps the evaluations of one Solve call.
+const solveBudget = 2000000
+
+// valueOrder tries printable values first (lowercase, uppercase, digits,
+// then the rest of ASCII), which is what challenge inputs usually are.
+var valueOrder = func() []byte {
+	var order []byte
+	used := [256]bool{}
+	add := func(from, to byte) {
+		for v := int(from); v <= int(to); v++ {
+			if !used[v] {
+				used[v] = true
+				order = append(order, byte(v))
+			}
+		}
+	}
+	add('a', 'z')
+	add('A', 'Z')
+	add('0', '9')
+	add(' ', '~')
+	add(0, 255)
+	return order
+}()
+
+// domain is the set of values an input byte may still take.
+type domain [4]uint64
+
+func fullDomain() domain {
+	return domain{^uint64(0), ^uint64(0), ^uint64(0), ^uint64(0)}
+}
+
+func (d *domain) has(v byte) bool {
+	return d[v>>6]&(1<<(v&63)) != 0
+}
+
+func (d *domain) remove(v byte) {
+	d[v>>6] &^= 1 << (v & 63)
+}
+
+func (d *domain) empty() bool {
+	return d[0]|d[1]|d[2]|d[3] == 0
+}
+
+type solver struct {
+	conds  []*Cond
+	vars   []int           // the input indexes to assign, in order
+	byVar  map[int][]*Cond // conditions mentioning each input
+	model  []byte
+	ev     *evaluator // of model
+	budget int
+}
+
+// Solve finds input values satisfying all conds. model has a value for
+// every input index used (and is updated in place when Sat), its values
+// are kept where the conditions allow.
+func Solve(conds []*Cond, model []byte) Result {
+	s := &solver{byVar: map[int][]*Cond{}, model: model, ev: newEvaluator(model), budget: solveBudget}
+
+	seen := map[int]bool{}
+	for _, c := range conds {
+		if c.IsConst() {
+			if !c.Holds(model) {
+				return Unsat
+			}
+			continue
+		}
+		s.conds = append(s.conds, c)
+		for _, v := range c.vars {
+			if !seen[v] {
+				seen[v] = true
+				s.vars = append(s.vars, v)
+			}
+			s.byVar[v] = append(s.byVar[v], c)
+		}
+	}
+	sort.Ints(s.vars)
+
+	// Quick check: the model may already be a solution
+	satisfied := true
+	for _, c := range s.conds {
+		if !s.ev.holds(c) {
+			satisfied = false
+			break
+		}
+	}
+	if satisfied {
+		return Sat
+	}
+
+	domains := make([]domain, len(model))
+	for _, v := range s.vars {
+		domains[v] = fullDomain()
+	}
+
+	// Conditions on a single input byte narrow its domain right away
+	for _, v := range s.vars {
+		for _, c := range s.byVar[v] {
+			if len(c.vars) == 1 && !s.narrow(c, v, domains) {
+				return Unsat
+			}
+		}
+	}
+
+	assigned := make([]bool, len(model))
+	if s.search(0, domains, assigned) {
+		return Sat
+	} else if s.budget <= 0 {
+		return Unknown
+	}
+	return Unsat
+}
+
+// search assigns s.vars[k:] by backtracking, checking the conditions left
+// with one unassigned input (forward checking).
+func (s *solver) search(k int, domains []domain, assigned []bool) bool {
+	if k == len(s.vars) {
+		return true
+	}
+	v := s.vars[k]
+	old := s.model[v]
+
+	// The current value first, so a model stays as close as possible
+	candidates := append([]byte{old}, valueOrder...)
+	for i, value := range candidates {
+		if i > 0 && value == old || !domains[v].has(value) {
+			continue
+		}
+		if s.budget--; s.budget <= 0 {
+			break
+		}
+
+		s.model[v] = value
+		assigned[v] = true
+
+		next := append([]domain{}, domains...)
+		if s.propagate(v, next, assigned) && s.search(k+1, next, assigned) {
+			return true
+		}
+		assigned[v] = false
+	}
+
+	s.model[v] = old
+	return false
+}
+
+// propagate checks the conditions of input v now it's assigned: fully
+// assigned ones must hold, the ones with a single unassigned input narrow
+// its domain.
+func (s *solver) propagate(v int, domains []domain, assigned []bool) bool {
+	for _, c := range s.byVar[v] {
+		free, unassigned := -1, 0
+		for _, u := range c.vars {
+			if !assigned[u] {
+				free = u
+				unassigned++
+			}
+		}
+
+		switch unassigned {
+		case 0:
+			s.ev.reset()
+			s.budget--
+			if !s.ev.holds(c) {
+				return false
+			}
+		case 1:
+			if !s.narrow(c, free, domains) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// narrow removes the values of input v which can't satisfy c, given the
+// other inputs of c are assigned.
+func (s *solver) narrow(c *Cond, v int, domains []domain) bool {
+	old := s.model[v]
+	d := &domains[v]
+	for value := 0; value < 256; value++ {
+		if !d.has(byte(value)) {
+			continue
+		}
+		s.model[v] = byte(value)
+		s.ev.reset()
+		s.budget--
+		if !s.ev.holds(c) {
+			d.remove(byte(value))
+		}
+	}
+	s.model[v] = old
+	return !d.empty()
+}
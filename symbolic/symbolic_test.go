@@ -0,0 +1,211 @@
+package symbolic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// keyProgram reads 4 bytes and jumps to ok if, XORed with 0x42, they
+// match secret ("s3cr").
+const keyProgram = `
+    SETT r5, 0
+    SETT r6, 1
+    SETT r7, 4
+loop:
+    LIK r5, r7
+    BHOPP ok
+    LES r2
+    SETT r3, 0x42
+    XELLER r2, r3
+    FINN secret
+    PLUSS r0, r5
+    LAST r4
+    ULIK r2, r4
+    BHOPP fail
+    PLUSS r5, r6
+    HOPP loop
+ok:
+    SETT r2, 'O'
+    SKRIV r2
+    SETT r2, 'K'
+    SKRIV r2
+fail:
+    STOPP
+secret:
+    .DATA 0x31, 0x71, 0x21, 0x30
+`
+
+// shiftProgram prints each input byte plus one.
+const shiftProgram = `
+    SETT r3, 1
+loop:
+    LES r2
+    PLUSS r2, r3
+    SKRIV r2
+    HOPP loop
+`
+
+func assemble(t *testing.T, src string) ([]byte, map[string]uint16) {
+	listing := assembler.List(src)
+	if err := listing.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(vm.SledeHeader), listing.Bytecode()...), listing.Labels
+}
+
+// run checks the solution on the VM, returning its output.
+func run(t *testing.T, program, input []byte, target int) []byte {
+	m, err := vm.NewVM(program, append([]byte{}, input...), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for m.State == vm.Running && int(m.PC) != target {
+		m.Step()
+	}
+	if target >= 0 && int(m.PC) != target {
+		t.Errorf("Input %q doesn't reach 0x%03x: %v", input, target, m.LastError)
+	}
+	return m.Output
+}
+
+func TestReach(t *testing.T) {
+	program, labels := assemble(t, keyProgram)
+	target := int(labels["ok"])
+
+	solution, stats, err := Explore(program, Options{Target: target, CycleLimit: 1000})
+	if err != nil {
+		t.Fatalf("%v (%+v)", err, stats)
+	}
+	if string(solution.Input) != "s3cr" {
+		t.Errorf("Expected input s3cr, got %q", solution.Input)
+	}
+	run(t, program, solution.Input, target)
+
+	// One failing path per byte, plus the one reaching ok
+	if stats.Paths != 5 {
+		t.Errorf("Expected 5 paths, got %+v", stats)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	program, _ := assemble(t, shiftProgram)
+
+	solution, _, err := Explore(program, Options{Target: -1, Output: []byte("IBM"), CycleLimit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(solution.Input) != "HAL" {
+		t.Errorf("Expected input HAL, got %q", solution.Input)
+	}
+	if output := run(t, program, solution.Input, -1); !bytes.Contains(output, []byte("IBM")) {
+		t.Errorf("Expected output IBM, got %q", output)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	program, labels := assemble(t, keyProgram)
+
+	_, _, err := Explore(program, Options{Target: int(labels["ok"]), MaxInput: 3, CycleLimit: 1000})
+	if err != ErrInputLimit {
+		t.Errorf("Expected ErrInputLimit, got %v", err)
+	}
+
+	_, _, err = Explore(program, Options{Target: -1, Output: []byte("KO"), CycleLimit: 1000})
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	_, _, err = Explore(program, Options{Target: int(labels["ok"]), MaxPaths: 2, CycleLimit: 1000})
+	if err != ErrPathLimit {
+		t.Errorf("Expected ErrPathLimit, got %v", err)
+	}
+}
+
+func TestConfirmed(t *testing.T) {
+	program, labels := assemble(t, keyProgram)
+	opts := Options{Target: int(labels["ok"])}
+
+	if !confirmed(program, &state{model: []byte("s3cr"), cycles: 1000}, opts) {
+		t.Error("Expected s3cr to be confirmed")
+	}
+	if confirmed(program, &state{model: []byte("s3cx"), cycles: 1000}, opts) {
+		t.Error("Expected s3cx to be rejected")
+	}
+	if confirmed(program, &state{model: []byte("s3cr"), cycles: 10}, opts) {
+		t.Error("Expected s3cr to be rejected in 10 cycles")
+	}
+}
+
+// Explorations share no state, go test -race checks it.
+func TestConcurrentExplore(t *testing.T) {
+	program, labels := assemble(t, keyProgram)
+
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			solution, _, err := Explore(program, Options{Target: int(labels["ok"]), CycleLimit: 1000})
+			if err == nil && string(solution.Input) != "s3cr" {
+				err = errors.Errorf("Expected input s3cr, got %q", solution.Input)
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSolve(t *testing.T) {
+	a, b := Input(0), Input(1)
+	sum, diff := Binary(OpAdd, a, b), Binary(OpSub, a, b)
+
+	tests := []struct {
+		conds  []*Cond
+		result Result
+	}{
+		{[]*Cond{NewCond(Eq, sum, Const(0x90)), NewCond(Eq, diff, Const(0x10))}, Sat},
+		{[]*Cond{NewCond(Gt, a, Const(0xf0)), NewCond(Lt, a, Const(0x10))}, Unsat},
+		{[]*Cond{NewCond(Eq, Binary(OpAnd, a, Const(1)), Const(1)), NewCond(Eq, Binary(OpShl, a, Const(1)), Const(0x20))}, Unsat},
+		{[]*Cond{NewCond(Ne, Binary(OpXor, a, b), Const(0)), NewCond(Eq, Binary(OpOr, a, b), Binary(OpAnd, a, b))}, Unsat},
+		{[]*Cond{NewCond(Ge, b, a), NewCond(Eq, Binary(OpShr, b, Const(4)), Const(7))}, Sat},
+	}
+
+	for i, test := range tests {
+		model := []byte{0, 0}
+		if result := Solve(test.conds, model); result != test.result {
+			t.Errorf("%d: expected %d, got %d", i, test.result, result)
+			continue
+		}
+		for _, c := range test.conds {
+			if test.result == Sat && !c.Holds(model) {
+				t.Errorf("%d: model %x doesn't satisfy %s", i, model, c)
+			}
+		}
+	}
+}
+
+func TestBinary(t *testing.T) {
+	a := Input(0)
+	if e := Binary(OpAdd, Const(3), Const(4)); !e.IsConst() || e.Value != 7 {
+		t.Errorf("Expected 0x07, got %s", e)
+	}
+	if e := Binary(OpXor, a, a); !e.IsConst() || e.Value != 0 {
+		t.Errorf("Expected 0x00, got %s", e)
+	}
+	if e := Binary(OpShl, a, Const(8)); !e.IsConst() || e.Value != 0 {
+		t.Errorf("Expected 0x00, got %s", e)
+	}
+	if e := Binary(OpOr, Const(0), a); e != a {
+		t.Errorf("Expected in[0], got %s", e)
+	}
+	if e := Binary(OpSub, Const(1), a); e.String() != "(0x01 - in[0])" || e.Eval([]byte{2}) != 0xff {
+		t.Errorf("Unexpected %s", e)
+	}
+}
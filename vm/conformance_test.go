@@ -0,0 +1,250 @@
+package vm_test
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// conformanceCase is a program with its setup and expected results, read
+// from testdata/conformance (see the README there for the format).
+type conformanceCase struct {
+	name string
+	pos  string // file:line, for failures
+
+	source     string
+	input      []byte
+	limit      int
+	stackLimit int
+
+	checks      map[string]string // state, cycles, output, stack
+	expressions []string          // e.g. r0 == 1, see evalExpectation
+}
+
+var keyRe = regexp.MustCompile(`^([a-z-]+):\s*(.*)$`)
+
+func readConformanceCases(path string) ([]*conformanceCase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cases []*conformanceCase
+	var c *conformanceCase
+	inExpect := false
+
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		pos := fmt.Sprintf("%s:%d", filepath.Base(path), number)
+
+		switch {
+		case strings.HasPrefix(line, "==="):
+			c = &conformanceCase{
+				name:       strings.TrimSpace(line[3:]),
+				pos:        pos,
+				limit:      1000,
+				stackLimit: vm.NPSTStackLimit,
+				checks:     map[string]string{},
+			}
+			cases = append(cases, c)
+			inExpect = false
+
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+
+		case c == nil:
+			return nil, errors.Errorf("%s: expected === <name>", pos)
+
+		case trimmed == "---":
+			inExpect = true
+
+		case !inExpect:
+			c.source += line + "\n"
+
+		default:
+			if err := c.parseExpectation(trimmed); err != nil {
+				return nil, errors.Wrap(err, pos)
+			}
+		}
+	}
+	return cases, scanner.Err()
+}
+
+func (c *conformanceCase) parseExpectation(line string) error {
+	match := keyRe.FindStringSubmatch(line)
+	if match == nil {
+		c.expressions = append(c.expressions, line)
+		return nil
+	}
+
+	key, value := match[1], match[2]
+	var err error
+	switch key {
+	case "input":
+		c.input, err = hex.DecodeString(strings.ReplaceAll(value, " ", ""))
+	case "limit":
+		c.limit, err = strconv.Atoi(value)
+	case "stack-limit":
+		c.stackLimit, err = strconv.Atoi(value)
+	case "state", "cycles", "output", "stack":
+		c.checks[key] = value
+	default:
+		return errors.Errorf("Unknown key: %s", key)
+	}
+	if err != nil {
+		return errors.Errorf("Bad %s: %s", key, value)
+	}
+	return nil
+}
+
+func (c *conformanceCase) newVM(t *testing.T) (*vm.VM, map[string]uint16) {
+	listing := assembler.List(c.source)
+	if err := listing.Err(); err != nil {
+		t.Fatalf("%s: %v", c.pos, err)
+	}
+
+	program := append([]byte(vm.SledeHeader), listing.Bytecode()...)
+	m, err := vm.NewVM(program, append([]byte{}, c.input...), c.limit)
+	if err != nil {
+		t.Fatalf("%s: %v", c.pos, err)
+	}
+	m.StackLimit = c.stackLimit
+	return m, listing.Labels
+}
+
+func (c *conformanceCase) check(t *testing.T, m *vm.VM, labels map[string]uint16) {
+	var stack []string
+	for _, addr := range m.Stack {
+		stack = append(stack, fmt.Sprintf("0x%03x", addr))
+	}
+
+	actual := map[string]string{
+		"state":  m.State.String(),
+		"cycles": strconv.Itoa(m.CycleCount),
+		"output": hex.EncodeToString(m.Output),
+		"stack":  strings.Join(stack, " "),
+	}
+	for key, expected := range c.checks {
+		if actual[key] != expected {
+			t.Errorf("%s: expected %s %q, got %q", c.pos, key, expected, actual[key])
+		}
+	}
+
+	for _, expr := range c.expressions {
+		ok, err := evalExpectation(m, labels, expr)
+		if err != nil {
+			t.Errorf("%s: %s: %v", c.pos, expr, err)
+		} else if !ok {
+			t.Errorf("%s: %s is false (pc 0x%03x, regs %x, flag %t, error %v)",
+				c.pos, expr, m.PC, m.Regs, m.Flag, m.LastError)
+		}
+	}
+}
+
+var (
+	registerRe = regexp.MustCompile(`^r(\d+)$`)
+	memoryRe   = regexp.MustCompile(`^mem\[(.+)\]$`)
+)
+
+// evalExpectation checks flag, !flag or <what> == <value>, what being a
+// register (r0-r15), pc, mem[<value>] or error (the ErrorKind name), and
+// a value a number, a character ('A') or a label.
+func evalExpectation(m *vm.VM, labels map[string]uint16, expr string) (bool, error) {
+	switch expr {
+	case "flag":
+		return m.Flag, nil
+	case "!flag":
+		return !m.Flag, nil
+	}
+
+	parts := strings.SplitN(expr, "==", 2)
+	if len(parts) != 2 {
+		return false, errors.New("Expected flag, !flag or <what> == <value>")
+	}
+	what, expected := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+	if what == "error" {
+		var vmErr *vm.VMError
+		return errors.As(m.LastError, &vmErr) && vmErr.Kind.String() == expected, nil
+	}
+
+	value, err := expectedValue(labels, expected)
+	if err != nil {
+		return false, err
+	}
+	switch match := registerRe.FindStringSubmatch(what); {
+	case what == "pc":
+		return int(m.PC) == value, nil
+	case match != nil:
+		r, _ := strconv.Atoi(match[1])
+		if r >= vm.RegCount {
+			return false, errors.Errorf("No register %s", what)
+		}
+		return int(m.Regs[r]) == value, nil
+	}
+	if match := memoryRe.FindStringSubmatch(what); match != nil {
+		addr, err := expectedValue(labels, match[1])
+		if err != nil {
+			return false, err
+		}
+		return int(m.Mem[addr%vm.MemSize]) == value, nil
+	}
+	return false, errors.Errorf("Unknown %s", what)
+}
+
+func expectedValue(labels map[string]uint16, text string) (int, error) {
+	if len(text) == 3 && text[0] == '\'' && text[2] == '\'' {
+		return int(text[1]), nil
+	}
+	if addr, ok := labels[text]; ok {
+		return int(addr), nil
+	}
+	value, err := strconv.ParseUint(text, 0, 16)
+	if err != nil {
+		return 0, errors.Errorf("Bad value: %s", text)
+	}
+	return int(value), nil
+}
+
+func TestConformance(t *testing.T) {
+	files, err := filepath.Glob("testdata/conformance/*.txt")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No conformance cases: %v", err)
+	}
+
+	for _, file := range files {
+		cases, err := readConformanceCases(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range cases {
+			c := c
+			t.Run(strings.TrimSuffix(filepath.Base(file), ".txt")+"/"+c.name, func(t *testing.T) {
+				m, labels := c.newVM(t)
+				m.Run()
+				c.check(t, m, labels)
+
+				// RunFast must agree, whatever the case covers
+				fast, _ := c.newVM(t)
+				fast.RunFast()
+				if diff := vm.DiffStates(m, fast); len(diff) > 0 {
+					t.Errorf("%s: RunFast differs: %v", c.pos, diff)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorKind classifies a VMError, the zero value means no error.
+type ErrorKind int
+
+const (
+	KindNoMoreInput ErrorKind = iota + 1
+	KindEmptyStack
+	KindCycleLimit
+	KindStackOverflow
+	KindBadInstruction
+	KindDevice
+)
+
+var kindNames = map[ErrorKind]string{
+	KindNoMoreInput:    "no_more_input",
+	KindEmptyStack:     "empty_stack",
+	KindCycleLimit:     "cycle_limit",
+	KindStackOverflow:  "stack_overflow",
+	KindBadInstruction: "bad_instruction",
+	KindDevice:         "device",
+}
+
+// kindErrors are the sentinels matched by errors.Is for each kind.
+var kindErrors = map[ErrorKind]error{
+	KindNoMoreInput:    ErrNoMoreInput,
+	KindEmptyStack:     ErrEmptyStack,
+	KindCycleLimit:     ErrCycleLimitExceeded,
+	KindStackOverflow:  ErrStackOverflow,
+	KindBadInstruction: ErrBadInstruction,
+	KindDevice:         ErrDevice,
+}
+
+func (k ErrorKind) String() string {
+	if name, found := kindNames[k]; found {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", int(k))
+}
+
+// ErrorKindByName is the reverse of ErrorKind.String.
+func ErrorKindByName(name string) (ErrorKind, bool) {
+	for kind, kindName := range kindNames {
+		if kindName == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+// ErrorKindOf classifies err, a VMError or one of the sentinels (e.g. from
+// an old state file), 0 if it's neither.
+func ErrorKindOf(err error) ErrorKind {
+	var vmErr *VMError
+	if errors.As(err, &vmErr) {
+		return vmErr.Kind
+	}
+	for kind, sentinel := range kindErrors {
+		if errors.Is(err, sentinel) {
+			return kind
+		}
+	}
+	return 0
+}
+
+// VMError is an error stopping the VM, along with where it happened.
+// errors.Is matches the sentinel of its kind, e.g. ErrNoMoreInput.
+type VMError struct {
+	Kind  ErrorKind
+	PC    uint16
+	Cycle int
+	Word  uint16 // the instruction at PC
+
+	// The sentinel, or the details of bad instructions and device errors
+	Err error
+}
+
+func (e *VMError) Error() string {
+	return fmt.Sprintf("%v at 0x%03x (cycle %d, %s)", e.Err, e.PC, e.Cycle, e.Describe())
+}
+
+// Describe is the raw instruction word, with the disassembly unless the
+// instruction is the problem.
+func (e *VMError) Describe() string {
+	if e.Kind == KindBadInstruction {
+		return fmt.Sprintf("word %04x", e.Word)
+	}
+	return fmt.Sprintf("word %04x: %s", e.Word, e.Instruction())
+}
+
+func (e *VMError) Unwrap() error {
+	return e.Err
+}
+
+// Cause is Unwrap for errors.Cause.
+func (e *VMError) Cause() error {
+	return e.Err
+}
+
+func (e *VMError) Is(target error) bool {
+	return target == kindErrors[e.Kind]
+}
+
+// Instruction decodes Word.
+func (e *VMError) Instruction() *Instruction {
+	return ParseInstruction(e.Word)
+}
+
+type vmErrorJSON struct {
+	Kind        string `json:"kind"`
+	Message     string `json:"message"`
+	PC          uint16 `json:"pc"`
+	Cycle       int    `json:"cycle"`
+	Word        string `json:"word"`
+	Instruction string `json:"instruction"`
+}
+
+func (e *VMError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(vmErrorJSON{
+		Kind:        e.Kind.String(),
+		Message:     e.Err.Error(),
+		PC:          e.PC,
+		Cycle:       e.Cycle,
+		Word:        fmt.Sprintf("%04x", e.Word),
+		Instruction: e.Instruction().String(),
+	})
+}
+
+func (e *VMError) UnmarshalJSON(data []byte) error {
+	var j vmErrorJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	kind, found := ErrorKindByName(j.Kind)
+	if !found {
+		return errors.Errorf("Unknown error kind: %s", j.Kind)
+	}
+	var word uint16
+	if _, err := fmt.Sscanf(j.Word, "%04x", &word); err != nil {
+		return errors.Errorf("Bad instruction word: %s", j.Word)
+	}
+
+	*e = VMError{Kind: kind, PC: j.PC, Cycle: j.Cycle, Word: word, Err: kindErrors[kind]}
+	if j.Message != e.Err.Error() {
+		e.Err = errors.New(j.Message)
+	}
+	return nil
+}
+
+// fail stops the VM with an error of the given kind at the current
+// instruction, err defaults to the sentinel of the kind.
+func (vm *VM) fail(kind ErrorKind, err error) error {
+	if err == nil {
+		err = kindErrors[kind]
+	}
+
+	vm.State = Error
+	vm.LastError = &VMError{
+		Kind:  kind,
+		PC:    vm.PC,
+		Cycle: vm.CycleCount,
+		Word:  vm.GetWord(vm.PC),
+		Err:   err,
+	}
+	return vm.LastError
+}
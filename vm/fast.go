@@ -0,0 +1,236 @@
+package vm
+
+type fastOp uint8
+
+const (
+	fastUndecoded fastOp = iota
+	fastSlow             // unsupported op, let Step report it
+	fastHalt
+	fastSetImm
+	fastSetReg
+	fastFinn
+	fastLoad
+	fastStore
+	fastAnd
+	fastOr
+	fastXor
+	fastShl
+	fastShr
+	fastAdd
+	fastSub
+	fastRead
+	fastWrite
+	fastEq
+	fastNe
+	fastLt
+	fastLe
+	fastGt
+	fastGe
+	fastJmp
+	fastCondJmp
+	fastCall
+	fastRet
+	fastNop
+)
+
+// decoded is a pre-decoded instruction word, a and b are registers (or
+// the immediate value of SETT).
+type decoded struct {
+	op   fastOp
+	a, b uint8
+	addr uint16
+}
+
+var (
+	aluOps = [...]fastOp{fastAnd, fastOr, fastXor, fastShl, fastShr, fastAdd, fastSub}
+	cmpOps = [...]fastOp{fastEq, fastNe, fastLt, fastLe, fastGt, fastGe}
+)
+
+func decode(w uint16) decoded {
+	class := OpClass(w & 0xf)
+	op := int((w >> 4) & 0xf)
+	arg1 := uint8((w >> 8) & 0xf)
+	arg2 := uint8((w >> 12) & 0xf)
+
+	switch class {
+	case OpClassHalt:
+		return decoded{op: fastHalt}
+	case OpClassMovImm:
+		return decoded{op: fastSetImm, a: uint8(op), b: uint8(w >> 8)}
+	case OpClassMovReg:
+		return decoded{op: fastSetReg, a: uint8(op), b: arg1}
+	case OpClassFinn:
+		return decoded{op: fastFinn, addr: w >> 4}
+	case OpClassLoadStore:
+		if op == 0 {
+			return decoded{op: fastLoad, a: arg1}
+		} else if op == 1 {
+			return decoded{op: fastStore, a: arg1}
+		}
+	case OpClassALU:
+		if op < len(aluOps) {
+			return decoded{op: aluOps[op], a: arg1, b: arg2}
+		}
+	case OpClassIO:
+		if op == 0 {
+			return decoded{op: fastRead, a: arg1}
+		} else if op == 1 {
+			return decoded{op: fastWrite, a: arg1}
+		}
+	case OpClassCmp:
+		if op < len(cmpOps) {
+			return decoded{op: cmpOps[op], a: arg1, b: arg2}
+		}
+	case OpClassJmp:
+		return decoded{op: fastJmp, addr: w >> 4}
+	case OpClassCondJmp:
+		return decoded{op: fastCondJmp, addr: w >> 4}
+	case OpClassCall:
+		return decoded{op: fastCall, addr: w >> 4}
+	case OpClassRet:
+		return decoded{op: fastRet}
+	case OpClassNop:
+		return decoded{op: fastNop}
+	}
+	return decoded{op: fastSlow}
+}
+
+// RunFast is Run for bulk execution (fuzzing, brute forcing): words are
+// decoded once into a table, which LAGR invalidates, and
+// break/watchpoints, self-modification tracking and memory checks are
+// ignored. Anything out of the ordinary (errors, input devices,
+// interactive input) is handed to Step, so the result is the same; Step
+// never writes memory in those cases so the table stays valid. Taint
+// tracking needs every instruction to go through Step.
+func (vm *VM) RunFast() error {
+	if vm.TrackTaint {
+		for vm.State == Running {
+			if err := vm.Step(); err != nil || vm.waitingForInput {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Memory may have been changed since the last run
+	if vm.decoded == nil {
+		vm.decoded = new([MemSize]decoded)
+	} else {
+		*vm.decoded = [MemSize]decoded{}
+	}
+	table := vm.decoded
+
+	vm.watchHit = nil
+	vm.waitingForInput = false
+	vm.selfMod = nil
+	vm.warning = nil
+
+	for vm.State == Running {
+		if vm.CycleLimit > 0 && vm.CycleCount >= vm.CycleLimit {
+			return vm.Step()
+		}
+
+		pc := vm.PC % MemSize
+		d := &table[pc]
+		if d.op == fastUndecoded {
+			*d = decode(vm.GetWord(pc))
+		}
+
+		nextPC := (vm.PC + 2) % MemSize
+		regs := &vm.Regs
+
+		switch d.op {
+		case fastHalt:
+			vm.State = Stopped
+			return nil
+		case fastSetImm:
+			regs[d.a] = d.b
+		case fastSetReg:
+			regs[d.a] = regs[d.b]
+		case fastFinn:
+			regs[0] = byte(d.addr)
+			regs[1] = byte(d.addr >> 8)
+		case fastLoad:
+			regs[d.a] = vm.Mem[vm.GetLoadStoreOffset()%MemSize]
+		case fastStore:
+			offset := vm.GetLoadStoreOffset() % MemSize
+			vm.Mem[offset] = regs[d.a]
+			vm.initialized[offset] = true
+			table[offset] = decoded{}
+			table[(offset+MemSize-1)%MemSize] = decoded{}
+		case fastAnd:
+			regs[d.a] &= regs[d.b]
+		case fastOr:
+			regs[d.a] |= regs[d.b]
+		case fastXor:
+			regs[d.a] ^= regs[d.b]
+		case fastShl:
+			regs[d.a] <<= regs[d.b]
+		case fastShr:
+			regs[d.a] >>= regs[d.b]
+		case fastAdd:
+			regs[d.a] += regs[d.b]
+		case fastSub:
+			regs[d.a] -= regs[d.b]
+		case fastEq:
+			vm.Flag = regs[d.a] == regs[d.b]
+		case fastNe:
+			vm.Flag = regs[d.a] != regs[d.b]
+		case fastLt:
+			vm.Flag = regs[d.a] < regs[d.b]
+		case fastLe:
+			vm.Flag = regs[d.a] <= regs[d.b]
+		case fastGt:
+			vm.Flag = regs[d.a] > regs[d.b]
+		case fastGe:
+			vm.Flag = regs[d.a] >= regs[d.b]
+		case fastJmp:
+			nextPC = d.addr
+		case fastCondJmp:
+			if vm.Flag {
+				nextPC = d.addr
+			}
+		case fastCall:
+			if vm.StackLimit > 0 && len(vm.Stack) >= vm.StackLimit {
+				return vm.Step()
+			}
+			vm.Stack = append(vm.Stack, nextPC)
+			nextPC = d.addr
+		case fastNop:
+
+		case fastRead:
+			if vm.InputIndex >= len(vm.Input) {
+				if err := vm.Step(); err != nil || vm.waitingForInput {
+					return err
+				}
+				continue
+			}
+			regs[d.a] = vm.Input[vm.InputIndex]
+			vm.InputIndex++
+		case fastWrite:
+			if vm.OutputSink != nil {
+				if err := vm.Step(); err != nil {
+					return err
+				}
+				continue
+			}
+			vm.Output = append(vm.Output, regs[d.a])
+		case fastRet:
+			if len(vm.Stack) == 0 {
+				return vm.Step()
+			}
+			nextPC = vm.Stack[len(vm.Stack)-1]
+			vm.Stack = vm.Stack[:len(vm.Stack)-1]
+
+		default:
+			if err := vm.Step(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		vm.PC = nextPC
+		vm.CycleCount++
+	}
+	return nil
+}
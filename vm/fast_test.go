@@ -0,0 +1,194 @@
+package vm_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// runSteps is the reference: Step until the VM stops, fails or waits.
+func runSteps(m *vm.VM) error {
+	for m.State == vm.Running {
+		if err := m.Step(); err != nil {
+			return err
+		} else if m.WaitingForInput() {
+			break
+		}
+	}
+	return nil
+}
+
+// randomProgram is mostly valid instructions jumping around and writing
+// within the first 256 bytes, so code gets modified as well.
+func randomProgram(r *rand.Rand) []byte {
+	program := []byte(vm.SledeHeader)
+	for i := 0; i < 128; i++ {
+		w := uint16(r.Intn(0x10000))
+		switch class := w & 0xf; {
+		case class == vm.OpClassFinn || class >= vm.OpClassJmp && class <= vm.OpClassCall:
+			w = w&0xf | uint16(r.Intn(0x100))<<4
+		case class == 0 && r.Intn(4) != 0:
+			w |= vm.OpClassNop
+		}
+		program = append(program, byte(w), byte(w>>8))
+	}
+	return program
+}
+
+func TestRunFastMatchesStep(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		program := randomProgram(r)
+		input := make([]byte, r.Intn(8))
+		r.Read(input)
+
+		slow, err := vm.NewVM(program, input, 2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fast, _ := vm.NewVM(program, input, 2000)
+		fast.InteractiveInput = i%2 == 0
+		slow.InteractiveInput = fast.InteractiveInput
+		fast.StackLimit = i % 4
+		slow.StackLimit = fast.StackLimit
+
+		slowErr := runSteps(slow)
+		fastErr := fast.RunFast()
+
+		if diff := vm.DiffStates(slow, fast); len(diff) != 0 || fmt.Sprint(slowErr) != fmt.Sprint(fastErr) {
+			t.Fatalf("Program %d (%x): %v, Step: %v, RunFast: %v", i, program, diff, slowErr, fastErr)
+		}
+		if slow.WaitingForInput() != fast.WaitingForInput() {
+			t.Fatalf("Program %d (%x): waiting for input differs", i, program)
+		}
+	}
+}
+
+func TestRunFastSelfModifying(t *testing.T) {
+	// After the first pass the byte at addr is overwritten with value,
+	// either the immediate of SETT r2, 1 or its opcode (0x31: SETT r3, 1)
+	tests := []struct {
+		addr, value byte
+		expected    byte
+	}{
+		{0x03, 2, 1 + 2 + 2},
+		{0x02, 0x31, 1 + 1},
+	}
+
+	for _, tc := range tests {
+		code, err := assembler.Assemble(fmt.Sprintf(`
+    SETT r4, 0
+patched:
+    SETT r2, 1
+    PLUSS r3, r2
+    SETT r1, 0
+    SETT r0, %d
+    SETT r5, %d
+    LAGR r5
+    SETT r6, 1
+    PLUSS r4, r6
+    SETT r6, 3
+    ULIK r4, r6
+    BHOPP patched
+    STOPP
+`, tc.addr, tc.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, _ := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, 1000)
+		if err := m.RunFast(); err != nil {
+			t.Fatal(err)
+		}
+		if m.Regs[3] != tc.expected {
+			t.Errorf("Patching 0x%02x: expected r3 = %d, got %d", tc.addr, tc.expected, m.Regs[3])
+		}
+	}
+}
+
+const benchmarkProgram = `
+    SETT r2, 1
+outer:
+    SETT r3, 0
+inner:
+    PLUSS r4, r3
+    XELLER r5, r4
+    PLUSS r3, r2
+    ULIK r3, r6
+    BHOPP inner
+    PLUSS r7, r2
+    HOPP outer
+`
+
+func newBenchmarkVM(b *testing.B) *vm.VM {
+	code, err := assembler.Assemble(benchmarkProgram)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	m, err := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, b.N)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return m
+}
+
+// Both benchmarks report ns per executed instruction
+
+func BenchmarkRun(b *testing.B) {
+	m := newBenchmarkVM(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	if err := m.Run(); !errors.Is(err, vm.ErrCycleLimitExceeded) {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkRunFast(b *testing.B) {
+	m := newBenchmarkVM(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	if err := m.RunFast(); !errors.Is(err, vm.ErrCycleLimitExceeded) {
+		b.Fatal(err)
+	}
+}
+
+// A TUR in the last word returns to 0
+func TestCallAtEndOfMemory(t *testing.T) {
+	code, err := assembler.Assemble("    HOPP 0xffe\nsub:\n    RETUR\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newVM := func() *vm.VM {
+		m, _ := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, 3)
+		m.Mem[0xffe], m.Mem[0xfff] = 0x2a, 0x00 // TUR sub
+		return m
+	}
+
+	slow := newVM()
+	slow.Step()
+	slow.Step()
+	if len(slow.Stack) != 1 || slow.Stack[0] != 0 {
+		t.Fatalf("Expected return address 0x000, got %x", slow.Stack)
+	}
+	slow.Step()
+	if slow.PC != 0 {
+		t.Fatalf("Expected to return to 0x000, got 0x%03x", slow.PC)
+	}
+	slow.Step() // the cycle limit
+
+	fast := newVM()
+	fast.RunFast()
+	if diff := vm.DiffStates(slow, fast); len(diff) != 0 {
+		t.Errorf("RunFast differs: %v", diff)
+	}
+}
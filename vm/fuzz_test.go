@@ -0,0 +1,56 @@
+//go:build go1.18
+// +build go1.18
+
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// FuzzStep runs arbitrary memory images from any PC: Step must never
+// panic, only fail with a VMError, and RunFast must agree with it.
+func FuzzStep(f *testing.F) {
+	for _, src := range []string{
+		"loop:\n    LES r0\n    SKRIV r0\n    HOPP loop\n",
+		"    TUR sub\n    STOPP\nsub:\n    FINN 0xfff\n    LAGR r2\n    RETUR\n",
+		"    SETT r15, 9\n    VSKIFT r0, r15\n    .DATA 0x75, 0xff\n",
+	} {
+		code, err := assembler.Assemble(src)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(code, []byte("input"), uint16(0))
+	}
+
+	f.Fuzz(func(t *testing.T, image, input []byte, pc uint16) {
+		if len(image) > vm.MemSize {
+			image = image[:vm.MemSize]
+		}
+		program := append([]byte(vm.SledeHeader), image...)
+
+		m, err := vm.NewVM(program, append([]byte{}, input...), 500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.PC = pc % vm.MemSize
+		m.CheckMemory = true
+		m.StackLimit = 64
+
+		for m.State == vm.Running {
+			if err := m.Step(); err != nil && vm.ErrorKindOf(err) == 0 {
+				t.Fatalf("Unexpected error at 0x%03x: %v", m.PC, err)
+			}
+		}
+
+		fast, _ := vm.NewVM(program, append([]byte{}, input...), 500)
+		fast.PC = pc % vm.MemSize
+		fast.StackLimit = 64
+		fast.RunFast()
+		if diff := vm.DiffStates(m, fast); len(diff) > 0 {
+			t.Errorf("RunFast differs: %v", diff)
+		}
+	})
+}
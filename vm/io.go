@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"bufio"
+	"io"
+)
+
+// InputSource supplies the bytes read by LES once VM.Input has been
+// consumed, io.EOF means there's no more input.
+type InputSource interface {
+	ReadByte() (byte, error)
+}
+
+// OutputSink receives the bytes written by SKRIV, in addition to
+// VM.Output.
+type OutputSink interface {
+	WriteByte(c byte) error
+}
+
+// InputFunc adapts a callback to an InputSource.
+type InputFunc func() (byte, error)
+
+func (f InputFunc) ReadByte() (byte, error) {
+	return f()
+}
+
+// OutputFunc adapts a callback to an OutputSink.
+type OutputFunc func(c byte) error
+
+func (f OutputFunc) WriteByte(c byte) error {
+	return f(c)
+}
+
+// ReaderInput reads input from r, e.g. os.Stdin or a net.Conn.
+func ReaderInput(r io.Reader) InputSource {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return bufio.NewReader(r)
+}
+
+// WriterOutput writes every byte to w as soon as it's output.
+func WriterOutput(w io.Writer) OutputSink {
+	return OutputFunc(func(c byte) error {
+		_, err := w.Write([]byte{c})
+		return err
+	})
+}
@@ -0,0 +1,85 @@
+package vm_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+const echoProgram = `
+loop:
+    LES r2
+    SKRIV r2
+    HOPP loop
+`
+
+func newEchoVM(t *testing.T, input []byte) *vm.VM {
+	code, err := assembler.Assemble(echoProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := vm.NewVM(append([]byte(vm.SledeHeader), code...), input, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestReaderInputWriterOutput(t *testing.T) {
+	m := newEchoVM(t, []byte("ab"))
+
+	var out bytes.Buffer
+	m.InputSource = vm.ReaderInput(strings.NewReader("cd"))
+	m.OutputSink = vm.WriterOutput(&out)
+
+	if err := m.Run(); !errors.Is(err, vm.ErrNoMoreInput) {
+		t.Fatalf("Expected ErrNoMoreInput, got %v", err)
+	}
+	if out.String() != "abcd" || string(m.Output) != "abcd" {
+		t.Errorf("Unexpected output %q / %q", out.String(), m.Output)
+	}
+	if string(m.Input) != "abcd" || m.InputIndex != 4 {
+		t.Errorf("Unexpected input %q at %d", m.Input, m.InputIndex)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	m := newEchoVM(t, nil)
+	m.InteractiveInput = true
+
+	next := byte('0')
+	m.InputSource = vm.InputFunc(func() (byte, error) {
+		if next > '2' {
+			return 0, io.EOF
+		}
+		next++
+		return next - 1, nil
+	})
+
+	var out []byte
+	m.OutputSink = vm.OutputFunc(func(c byte) error {
+		out = append(out, c)
+		return nil
+	})
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.WaitingForInput() || string(out) != "012" {
+		t.Errorf("Expected to wait for input after \"012\", got %q", out)
+	}
+
+	failure := errors.New("Broken pipe")
+	m.OutputSink = vm.OutputFunc(func(c byte) error { return failure })
+	m.Input = append(m.Input, 'x')
+	if err := m.Run(); errors.Cause(err) != failure || m.State != vm.Error {
+		t.Errorf("Expected output error, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package vm
+
+import "fmt"
+
+// SelfModification is reported when LAGR changes a byte of an already
+// executed instruction, or when an instruction changed at runtime is
+// executed.
+type SelfModification struct {
+	PC        uint16 // the writing (or the modified) instruction
+	Addr      uint16 // the changed byte
+	Executing bool
+}
+
+func (sm SelfModification) String() string {
+	if sm.Executing {
+		return fmt.Sprintf("Executing modified code at 0x%03x", sm.PC)
+	}
+	return fmt.Sprintf("0x%03x modified executed code at 0x%03x", sm.PC, sm.Addr)
+}
+
+// SelfModified returns the self-modification detected by the last Step.
+func (vm *VM) SelfModified() (SelfModification, bool) {
+	if vm.selfMod == nil {
+		return SelfModification{}, false
+	}
+	return *vm.selfMod, true
+}
+
+// Executed reports whether the byte at addr has been part of an executed
+// instruction.
+func (vm *VM) Executed(addr uint16) bool {
+	return vm.executed[addr%MemSize]
+}
+
+// Modified reports whether the byte at addr differs from the loaded program.
+func (vm *VM) Modified(addr uint16) bool {
+	return vm.Mem[addr%MemSize] != vm.Original[addr%MemSize]
+}
+
+// OriginalWord is GetWord of the loaded program.
+func (vm *VM) OriginalWord(offset uint16) uint16 {
+	return uint16(vm.Original[offset%MemSize]) +
+		uint16(vm.Original[(offset+1)%MemSize])<<8
+}
+
+// trackExecution is called before executing the word at PC.
+func (vm *VM) trackExecution() {
+	pc, next := vm.PC%MemSize, (vm.PC+1)%MemSize
+
+	if vm.written[pc] || vm.written[next] {
+		addr := pc
+		if !vm.written[pc] {
+			addr = next
+		}
+		vm.selfMod = &SelfModification{PC: pc, Addr: addr, Executing: true}
+		vm.written[pc], vm.written[next] = false, false
+	}
+
+	vm.executed[pc], vm.executed[next] = true, true
+}
+
+// trackWrite is called before LAGR changes the byte at addr.
+func (vm *VM) trackWrite(addr uint16, value byte) {
+	if vm.Mem[addr] == value {
+		return
+	}
+
+	if vm.executed[addr] {
+		vm.selfMod = &SelfModification{PC: vm.PC % MemSize, Addr: addr}
+	}
+	vm.written[addr] = true
+}
+
+// ModifiedCodeNext reports whether the next instruction has been changed
+// at runtime since it was last executed (or at all, if it hasn't been).
+func (vm *VM) ModifiedCodeNext() bool {
+	return vm.written[vm.PC%MemSize] || vm.written[(vm.PC+1)%MemSize]
+}
@@ -0,0 +1,62 @@
+package vm
+
+import "fmt"
+
+type WarningKind int
+
+const (
+	WarnUninitializedRead WarningKind = iota
+	WarnPastProgramEnd
+	WarnPCWraparound
+)
+
+// Warning is a likely bug found by the memory checks (see CheckMemory).
+type Warning struct {
+	Kind WarningKind
+	PC   uint16
+	Addr uint16 // the byte read by LAST
+}
+
+func (w Warning) String() string {
+	switch w.Kind {
+	case WarnUninitializedRead:
+		return fmt.Sprintf("0x%03x read uninitialized memory at 0x%03x", w.PC, w.Addr)
+	case WarnPastProgramEnd:
+		return fmt.Sprintf("Executing past the end of the program at 0x%03x", w.PC)
+	default:
+		return fmt.Sprintf("PC wrapped around after 0x%03x", w.PC)
+	}
+}
+
+// Warning returns what the memory checks found in the last Step.
+func (vm *VM) Warning() (Warning, bool) {
+	if vm.warning == nil {
+		return Warning{}, false
+	}
+	return *vm.warning, true
+}
+
+// Initialized reports whether the byte at addr was loaded from the
+// program or has been written (by LAGR or SetByte).
+func (vm *VM) Initialized(addr uint16) bool {
+	return vm.initialized[addr%MemSize]
+}
+
+func (vm *VM) warn(kind WarningKind, addr uint16) {
+	if vm.CheckMemory {
+		vm.warning = &Warning{Kind: kind, PC: vm.PC % MemSize, Addr: addr}
+	}
+}
+
+// checkExecution is called before executing the word at PC. Execution
+// past the end is reported when entering that area, code written there
+// at runtime doesn't count.
+func (vm *VM) checkExecution() {
+	pastEnd := int(vm.PC%MemSize) >= vm.ProgramSize &&
+		!vm.initialized[vm.PC%MemSize] && !vm.initialized[(vm.PC+1)%MemSize]
+
+	if pastEnd && !vm.pastEnd {
+		vm.warn(WarnPastProgramEnd, vm.PC%MemSize)
+	}
+	vm.pastEnd = pastEnd
+}
@@ -0,0 +1,325 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	stateFormat  = "SLEDE8 state"
+	StateVersion = 1
+)
+
+var stateNames = map[VMState]string{
+	Running: "running",
+	Stopped: "stopped",
+	Error:   "error",
+}
+
+func (s VMState) String() string {
+	return stateNames[s]
+}
+
+// state is the on-disk snapshot of a VM, byte arrays are hex encoded.
+type state struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+
+	Flag bool   `json:"flag"`
+	PC   uint16 `json:"pc"`
+	Regs string `json:"regs"`
+	Mem  string `json:"mem"`
+
+	// Only when different from mem
+	Original string `json:"original,omitempty"`
+
+	// Bitmap of bytes loaded or written, for the memory checks
+	ProgramSize int    `json:"programSize"`
+	Initialized string `json:"initialized,omitempty"`
+
+	Input      string   `json:"input"`
+	InputIndex int      `json:"inputIndex"`
+	Output     string   `json:"output"`
+	Stack      []uint16 `json:"stack"`
+	StackLimit int      `json:"stackLimit,omitempty"`
+
+	CycleCount int `json:"cycleCount"`
+	CycleLimit int `json:"cycleLimit"`
+
+	State     string   `json:"state"`
+	LastError string   `json:"lastError,omitempty"`
+	Error     *VMError `json:"error,omitempty"`
+
+	BreakPoints []uint16 `json:"breakpoints,omitempty"`
+	WatchPoints []uint16 `json:"watchpoints,omitempty"`
+}
+
+// SaveState writes a snapshot of the whole VM, including break and
+// watchpoints.
+func (vm *VM) SaveState(w io.Writer) error {
+	s := state{
+		Format:     stateFormat,
+		Version:    StateVersion,
+		Flag:       vm.Flag,
+		PC:         vm.PC,
+		Regs:       hex.EncodeToString(vm.Regs[:]),
+		Mem:        hex.EncodeToString(vm.Mem[:]),
+		Input:      hex.EncodeToString(vm.Input),
+		InputIndex: vm.InputIndex,
+		Output:     hex.EncodeToString(vm.Output),
+		Stack:      append([]uint16{}, vm.Stack...),
+		StackLimit: vm.StackLimit,
+		CycleCount: vm.CycleCount,
+		CycleLimit: vm.CycleLimit,
+		State:      stateNames[vm.State],
+	}
+
+	if vm.LastError != nil {
+		s.LastError = vm.LastError.Error()
+		errors.As(vm.LastError, &s.Error)
+	}
+	if vm.Original != vm.Mem {
+		s.Original = hex.EncodeToString(vm.Original[:])
+	}
+
+	s.ProgramSize = vm.ProgramSize
+	var initialized [MemSize / 8]byte
+	for addr, set := range vm.initialized {
+		if set {
+			initialized[addr/8] |= 1 << (addr % 8)
+		}
+	}
+	s.Initialized = hex.EncodeToString(initialized[:])
+
+	s.BreakPoints, s.WatchPoints = vm.breakAndWatchPoints()
+
+	data, err := json.MarshalIndent(&s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// LoadState reads a snapshot written by SaveState.
+func LoadState(r io.Reader) (*VM, error) {
+	var s state
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, errors.Wrap(err, "Bad state file")
+	}
+
+	if s.Format != stateFormat {
+		return nil, errors.New("Not a SLEDE8 state file")
+	} else if s.Version != StateVersion {
+		return nil, errors.Errorf("Unsupported state version %d (expected %d)",
+			s.Version, StateVersion)
+	}
+
+	vm := &VM{
+		Flag:       s.Flag,
+		PC:         s.PC % MemSize,
+		InputIndex: s.InputIndex,
+		Stack:      s.Stack,
+		StackLimit: s.StackLimit,
+		CycleCount: s.CycleCount,
+		CycleLimit: s.CycleLimit,
+	}
+
+	if err := decodeHexField("regs", s.Regs, vm.Regs[:]); err != nil {
+		return nil, err
+	}
+	if err := decodeHexField("mem", s.Mem, vm.Mem[:]); err != nil {
+		return nil, err
+	}
+
+	// Files without the bitmap count as fully initialized
+	vm.ProgramSize = s.ProgramSize
+	var initialized [MemSize / 8]byte
+	if s.Initialized == "" {
+		for i := range initialized {
+			initialized[i] = 0xff
+		}
+	} else if err := decodeHexField("initialized", s.Initialized, initialized[:]); err != nil {
+		return nil, err
+	}
+	for addr := range vm.initialized {
+		vm.initialized[addr] = initialized[addr/8]&(1<<(addr%8)) != 0
+	}
+
+	vm.Original = vm.Mem
+	if s.Original != "" {
+		if err := decodeHexField("original", s.Original, vm.Original[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if vm.Input, err = hex.DecodeString(s.Input); err != nil {
+		return nil, errors.Wrap(err, "Bad input")
+	}
+	if vm.Output, err = hex.DecodeString(s.Output); err != nil {
+		return nil, errors.Wrap(err, "Bad output")
+	}
+	if vm.InputIndex < 0 || vm.InputIndex > len(vm.Input) {
+		return nil, errors.Errorf("Input index %d is out of range", vm.InputIndex)
+	}
+
+	found := false
+	for state, name := range stateNames {
+		if name == s.State {
+			vm.State = state
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("Unknown VM state: %s", s.State)
+	}
+
+	// Older files only have the message
+	if s.Error != nil {
+		vm.LastError = s.Error
+	} else if s.LastError != "" {
+		vm.LastError = errors.New(s.LastError)
+		for _, known := range []error{ErrNoMoreInput, ErrEmptyStack, ErrCycleLimitExceeded, ErrStackOverflow} {
+			if known.Error() == s.LastError {
+				vm.LastError = known
+			}
+		}
+	}
+
+	for _, addr := range s.BreakPoints {
+		if !vm.BreakpointSet(addr) {
+			vm.ToggleBreakpoint(addr)
+		}
+	}
+	for _, addr := range s.WatchPoints {
+		vm.WatchPoints[addr%MemSize] = true
+	}
+
+	return vm, nil
+}
+
+func (vm *VM) breakAndWatchPoints() (breakPoints, watchPoints []uint16) {
+	for addr := uint16(0); addr < MemSize; addr++ {
+		if addr%2 == 0 && vm.BreakpointSet(addr) {
+			breakPoints = append(breakPoints, addr)
+		}
+		if vm.WatchPoints[addr] {
+			watchPoints = append(watchPoints, addr)
+		}
+	}
+	return
+}
+
+func decodeHexField(name, s string, dst []byte) error {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return errors.Wrapf(err, "Bad %s", name)
+	} else if len(data) != len(dst) {
+		return errors.Errorf("Expected %d bytes of %s, got %d", len(dst), name, len(data))
+	}
+	copy(dst, data)
+	return nil
+}
+
+// DiffStates describes the differences between two VMs, one line per
+// changed item. Runs of changed memory are reported together.
+func DiffStates(a, b *VM) []string {
+	var diff []string
+	add := func(format string, args ...interface{}) {
+		diff = append(diff, fmt.Sprintf(format, args...))
+	}
+
+	if a.PC != b.PC {
+		add("pc: 0x%03x -> 0x%03x", a.PC, b.PC)
+	}
+	if a.Flag != b.Flag {
+		add("flag: %t -> %t", a.Flag, b.Flag)
+	}
+	for i := range a.Regs {
+		if a.Regs[i] != b.Regs[i] {
+			add("r%d: 0x%02x -> 0x%02x", i, a.Regs[i], b.Regs[i])
+		}
+	}
+
+	for addr := 0; addr < MemSize; addr++ {
+		if a.Mem[addr] == b.Mem[addr] {
+			continue
+		}
+
+		end := addr + 1
+		for end < MemSize && a.Mem[end] != b.Mem[end] {
+			end++
+		}
+
+		if end-addr == 1 {
+			add("mem[0x%03x]: %02x -> %02x", addr, a.Mem[addr], b.Mem[addr])
+		} else {
+			add("mem[0x%03x..0x%03x]: %x -> %x", addr, end-1, a.Mem[addr:end], b.Mem[addr:end])
+		}
+		addr = end
+	}
+
+	if formatAddrs(a.Stack) != formatAddrs(b.Stack) {
+		add("stack: %s -> %s", formatAddrs(a.Stack), formatAddrs(b.Stack))
+	}
+	if !bytes.Equal(a.Input, b.Input) {
+		add("input: %s -> %s", formatBytes(a.Input), formatBytes(b.Input))
+	}
+	if a.InputIndex != b.InputIndex {
+		add("input index: %d -> %d", a.InputIndex, b.InputIndex)
+	}
+	if !bytes.Equal(a.Output, b.Output) {
+		add("output: %s -> %s", formatBytes(a.Output), formatBytes(b.Output))
+	}
+	if a.CycleCount != b.CycleCount {
+		add("cycles: %d -> %d", a.CycleCount, b.CycleCount)
+	}
+	if a.CycleLimit != b.CycleLimit {
+		add("cycle limit: %d -> %d", a.CycleLimit, b.CycleLimit)
+	}
+	if a.StackLimit != b.StackLimit {
+		add("stack limit: %d -> %d", a.StackLimit, b.StackLimit)
+	}
+	if a.State != b.State {
+		add("state: %s -> %s", stateNames[a.State], stateNames[b.State])
+	}
+	if fmt.Sprint(a.LastError) != fmt.Sprint(b.LastError) {
+		add("error: %v -> %v", a.LastError, b.LastError)
+	}
+
+	aBreak, aWatch := a.breakAndWatchPoints()
+	bBreak, bWatch := b.breakAndWatchPoints()
+	if formatAddrs(aBreak) != formatAddrs(bBreak) {
+		add("breakpoints: %s -> %s", formatAddrs(aBreak), formatAddrs(bBreak))
+	}
+	if formatAddrs(aWatch) != formatAddrs(bWatch) {
+		add("watchpoints: %s -> %s", formatAddrs(aWatch), formatAddrs(bWatch))
+	}
+
+	return diff
+}
+
+func formatAddrs(addrs []uint16) string {
+	s := "["
+	for i, addr := range addrs {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprintf("0x%03x", addr)
+	}
+	return s + "]"
+}
+
+func formatBytes(data []byte) string {
+	if len(data) == 0 {
+		return "(empty)"
+	}
+	return hex.EncodeToString(data)
+}
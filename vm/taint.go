@@ -0,0 +1,201 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Taint is the set of input bytes (indexes of bytes read by LES) a value
+// depends on, sorted. Taints are shared, so never modify one.
+type Taint []int
+
+// Union is the set of input bytes of either taint.
+func (t Taint) Union(other Taint) Taint {
+	switch {
+	case len(other) == 0:
+		return t
+	case len(t) == 0:
+		return other
+	}
+
+	union := make(Taint, 0, len(t)+len(other))
+	i, j := 0, 0
+	for i < len(t) || j < len(other) {
+		switch {
+		case j == len(other) || i < len(t) && t[i] < other[j]:
+			union = append(union, t[i])
+			i++
+		case i == len(t) || other[j] < t[i]:
+			union = append(union, other[j])
+			j++
+		default:
+			union = append(union, t[i])
+			i, j = i+1, j+1
+		}
+	}
+
+	// Keep sharing when nothing was added
+	if len(union) == len(t) {
+		return t
+	} else if len(union) == len(other) {
+		return other
+	}
+	return union
+}
+
+// Has reports whether input byte index is in the set.
+func (t Taint) Has(index int) bool {
+	for _, i := range t {
+		if i == index {
+			return true
+		} else if i > index {
+			break
+		}
+	}
+	return false
+}
+
+// Equal reports whether both are the same set.
+func (t Taint) Equal(other Taint) bool {
+	if len(t) != len(other) {
+		return false
+	}
+	for i := range t {
+		if t[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the set with ranges, e.g. "in[0-3,7]".
+func (t Taint) String() string {
+	if len(t) == 0 {
+		return "-"
+	}
+
+	var parts []string
+	for start := 0; start < len(t); {
+		end := start
+		for end+1 < len(t) && t[end+1] == t[end]+1 {
+			end++
+		}
+		if end > start {
+			parts = append(parts, fmt.Sprintf("%d-%d", t[start], t[end]))
+		} else {
+			parts = append(parts, fmt.Sprint(t[start]))
+		}
+		start = end + 1
+	}
+	return "in[" + strings.Join(parts, ",") + "]"
+}
+
+// taintState is the shadow of everything taint flows through, allocated
+// on the first Step with TrackTaint.
+type taintState struct {
+	regs     [RegCount]Taint
+	mem      [MemSize]Taint
+	flag     Taint
+	output   []Taint
+	branches [MemSize]Taint // of every decision of BHOPP at the address
+}
+
+// propagateTaint is called before executing i. LAST and LAGR depend on
+// the address too, so table lookups indexed by input stay tainted. Stack
+// addresses don't hold data, they aren't tracked.
+func (vm *VM) propagateTaint(i *Instruction) {
+	if vm.taint == nil {
+		vm.taint = &taintState{}
+	}
+	t := vm.taint
+
+	switch i.Class {
+	case OpClassMovImm:
+		t.regs[i.Op] = nil
+
+	case OpClassMovReg:
+		t.regs[i.Op] = t.regs[i.Arg1]
+
+	case OpClassFinn:
+		t.regs[0], t.regs[1] = nil, nil
+
+	case OpClassLoadStore:
+		offset := vm.GetLoadStoreOffset() % MemSize
+		address := t.regs[0].Union(t.regs[1])
+		if i.Op == 0 {
+			t.regs[i.Arg1] = t.mem[offset].Union(address)
+		} else if i.Op == 1 {
+			t.mem[offset] = t.regs[i.Arg1].Union(address)
+		}
+
+	case OpClassALU:
+		// XELLER r, r and MINUS r, r are the usual ways of clearing r
+		if i.Arg1 == i.Arg2 && (i.Op == 2 || i.Op == 6) {
+			t.regs[i.Arg1] = nil
+		} else if i.Op <= 6 {
+			t.regs[i.Arg1] = t.regs[i.Arg1].Union(t.regs[i.Arg2])
+		}
+
+	case OpClassIO:
+		if i.Op == 0 {
+			t.regs[i.Arg1] = Taint{vm.InputIndex}
+		} else if i.Op == 1 {
+			// Output may predate tracking
+			for len(t.output) < len(vm.Output) {
+				t.output = append(t.output, nil)
+			}
+			t.output = append(t.output[:len(vm.Output)], t.regs[i.Arg1])
+		}
+
+	case OpClassCmp:
+		if i.Op <= 5 {
+			t.flag = t.regs[i.Arg1].Union(t.regs[i.Arg2])
+		}
+
+	case OpClassCondJmp:
+		pc := vm.PC % MemSize
+		t.branches[pc] = t.branches[pc].Union(t.flag)
+	}
+}
+
+// RegTaint is the input bytes register reg depends on (see TrackTaint).
+func (vm *VM) RegTaint(reg int) Taint {
+	regIndexSanityCheck(reg)
+	if vm.taint == nil {
+		return nil
+	}
+	return vm.taint.regs[reg]
+}
+
+// MemTaint is the input bytes the byte at addr depends on.
+func (vm *VM) MemTaint(addr uint16) Taint {
+	if vm.taint == nil {
+		return nil
+	}
+	return vm.taint.mem[addr%MemSize]
+}
+
+// FlagTaint is the input bytes Flag depends on.
+func (vm *VM) FlagTaint() Taint {
+	if vm.taint == nil {
+		return nil
+	}
+	return vm.taint.flag
+}
+
+// OutputTaint is the input bytes output byte index depends on.
+func (vm *VM) OutputTaint(index int) Taint {
+	if vm.taint == nil || index >= len(vm.taint.output) {
+		return nil
+	}
+	return vm.taint.output[index]
+}
+
+// BranchTaint is the input bytes the decisions of BHOPP at addr depended
+// on, so far.
+func (vm *VM) BranchTaint(addr uint16) Taint {
+	if vm.taint == nil {
+		return nil
+	}
+	return vm.taint.branches[addr%MemSize]
+}
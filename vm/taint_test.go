@@ -0,0 +1,88 @@
+package vm_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+// taintProgram checks in[1] + in[2] against a table indexed by in[0],
+// printing in[3] and a constant.
+const taintProgram = `
+    LES r4
+    LES r5
+    LES r6
+    PLUSS r5, r6
+    FINN table
+    PLUSS r0, r4
+    LAST r7
+    LIK r5, r7
+    BHOPP ok
+ok:
+    LES r8
+    FINN buffer
+    LAGR r8
+    SETT r9, 0
+    XELLER r8, r8
+    LAST r10
+    SKRIV r10
+    SKRIV r9
+    STOPP
+table:
+    .DATA 1, 2, 3
+buffer:
+    .DATA 0
+`
+
+func TestTaint(t *testing.T) {
+	listing := assembler.List(taintProgram)
+	if err := listing.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ := vm.NewVM(append([]byte(vm.SledeHeader), listing.Bytecode()...), []byte{1, 2, 3, 4}, 100)
+	m.TrackTaint = true
+	m.RunFast()
+	if m.State != vm.Stopped {
+		t.Fatal(m.LastError)
+	}
+
+	taints := []struct {
+		what     string
+		actual   vm.Taint
+		expected string
+	}{
+		{"r4", m.RegTaint(4), "in[0]"},
+		{"r5", m.RegTaint(5), "in[1-2]"},
+		{"r7", m.RegTaint(7), "in[0]"},
+		{"r8", m.RegTaint(8), "-"},
+		{"r9", m.RegTaint(9), "-"},
+		{"r10", m.RegTaint(10), "in[3]"},
+		{"flag", m.FlagTaint(), "in[0-2]"},
+		{"branch", m.BranchTaint(listing.Labels["ok"] - 2), "in[0-2]"},
+		{"buffer", m.MemTaint(listing.Labels["buffer"]), "in[3]"},
+		{"table", m.MemTaint(listing.Labels["table"]), "-"},
+		{"output 0", m.OutputTaint(0), "in[3]"},
+		{"output 1", m.OutputTaint(1), "-"},
+	}
+	for _, taint := range taints {
+		if actual := fmt.Sprint(taint.actual); actual != taint.expected {
+			t.Errorf("%s: expected %s, got %s", taint.what, taint.expected, actual)
+		}
+	}
+}
+
+func TestTaintUnion(t *testing.T) {
+	a, b := vm.Taint{0, 2, 3, 7}, vm.Taint{1, 3, 8}
+	if union := a.Union(b); fmt.Sprint(union) != "in[0-3,7-8]" {
+		t.Errorf("Unexpected union %s", union)
+	}
+	if union := a.Union(vm.Taint{2, 7}); &union[0] != &a[0] {
+		t.Errorf("Expected %s to be shared", union)
+	}
+	if !a.Has(3) || a.Has(4) {
+		t.Errorf("Has is wrong for %s", a)
+	}
+}
@@ -2,6 +2,7 @@ package vm
 
 import (
 	"bytes"
+	"io"
 
 	"github.com/pkg/errors"
 )
@@ -11,6 +12,11 @@ const (
 	MemSize  = 4096
 
 	SledeHeader = ".SLEDE8"
+
+	// NPSTStackLimit is the call depth of the NPST runtime
+	// (https://slede8.npst.no), the default StackLimit: a TUR with this
+	// many return addresses on the stack fails with "Stack overflow".
+	NPSTStackLimit = 254
 )
 
 type VMState int
@@ -25,6 +31,9 @@ var (
 	ErrNoMoreInput        = errors.New("No more input available")
 	ErrEmptyStack         = errors.New("Stack is empty")
 	ErrCycleLimitExceeded = errors.New("Cycle limit exceeded")
+	ErrStackOverflow      = errors.New("Stack overflow")
+	ErrBadInstruction     = errors.New("Unsupported instruction")
+	ErrDevice             = errors.New("I/O device error")
 )
 
 type VM struct {
@@ -34,20 +43,66 @@ type VM struct {
 
 	Mem [MemSize]byte
 
+	// Memory as loaded, for spotting self-modifying code
+	Original [MemSize]byte
+
 	Input      []byte
 	InputIndex int
 
+	// LES without remaining input pauses (see WaitingForInput) instead of
+	// failing with ErrNoMoreInput
+	InteractiveInput bool
+	waitingForInput  bool
+
+	// Optional devices, bytes read from InputSource are appended to Input
+	InputSource InputSource
+	OutputSink  OutputSink
+
 	Output []byte
 	Stack  []uint16
 
+	// Maximum call depth, TUR fails with ErrStackOverflow beyond it
+	// (NPSTStackLimit by default, 0: unlimited)
+	StackLimit int
+
 	CycleCount int
 	CycleLimit int
 
 	State     VMState
-	LastError error
+	LastError error // a *VMError when set by Step
 
 	// Single breakpoint "covers" the whole word
 	BreakPoints [MemSize / 2]bool
+
+	// Run stops after an instruction changes a watched byte
+	WatchPoints [MemSize]bool
+	watchHit    *uint16
+
+	// Run also stops at self-modifying code: after a write to executed
+	// code and before executing modified code
+	BreakOnSelfModify bool
+	selfMod           *SelfModification
+	executed          [MemSize]bool
+	written           [MemSize]bool // changed since last executed
+
+	// Memory checks ("shadow memory"): LAST of bytes neither loaded nor
+	// written, executing past the program and PC wraparound are reported
+	// by Warning, Run stops at them with BreakOnWarning
+	CheckMemory    bool
+	BreakOnWarning bool
+	ProgramSize    int
+	initialized    [MemSize]bool
+	pastEnd        bool
+	warning        *Warning
+
+	// Taint tracking: which input bytes the registers, memory, Flag,
+	// output and branch decisions depend on (see RegTaint etc.), from
+	// when it's enabled. Not saved in snapshots.
+	TrackTaint bool
+	taint      *taintState
+
+	// Instruction table of RunFast
+	decoded *[MemSize]decoded
 }
 
 func NewVM(program, input []byte, cycleLimit int) (*VM, error) {
@@ -68,23 +123,89 @@ func NewVM(program, input []byte, cycleLimit int) (*VM, error) {
 	}
 
 	vm := &VM{
-		Input:      input,
-		CycleLimit: cycleLimit,
+		Input:       input,
+		CycleLimit:  cycleLimit,
+		StackLimit:  NPSTStackLimit,
+		ProgramSize: codeSize,
+	}
+
+	for i := 0; i < codeSize; i++ {
+		vm.initialized[i] = true
 	}
 
-	if _, err := r.Read(vm.Mem[:]); err != nil {
+	// An empty program is just STOPP
+	if _, err := r.Read(vm.Mem[:]); err != nil && err != io.EOF {
 		return nil, errors.WithStack(err)
 	}
+	vm.Original = vm.Mem
 
 	return vm, nil
 }
 
+// KeepSettings copies what isn't execution state (break/watchpoints,
+// input mode, I/O devices, ...) from another VM, e.g. when restarting.
+func (vm *VM) KeepSettings(from *VM) {
+	vm.InteractiveInput = from.InteractiveInput
+	vm.InputSource = from.InputSource
+	vm.OutputSink = from.OutputSink
+	vm.BreakPoints = from.BreakPoints
+	vm.WatchPoints = from.WatchPoints
+	vm.StackLimit = from.StackLimit
+	vm.BreakOnSelfModify = from.BreakOnSelfModify
+	vm.CheckMemory = from.CheckMemory
+	vm.BreakOnWarning = from.BreakOnWarning
+	vm.TrackTaint = from.TrackTaint
+}
+
+// Clone is a deep copy of the VM, e.g. one per goroutine. I/O devices
+// are shared.
+func (vm *VM) Clone() *VM {
+	clone := *vm
+	clone.Input = append([]byte(nil), vm.Input...)
+	clone.Output = append([]byte(nil), vm.Output...)
+	clone.Stack = append([]uint16(nil), vm.Stack...)
+	clone.decoded = nil
+
+	if vm.taint != nil {
+		taint := *vm.taint
+		taint.output = append([]Taint(nil), vm.taint.output...)
+		clone.taint = &taint
+	}
+	return &clone
+}
+
+// Reset puts the VM back in the state NewVM loaded it in, with new input,
+// without parsing the program again. Settings (see KeepSettings) and the
+// cycle limit are kept.
+func (vm *VM) Reset(input []byte) {
+	fresh := VM{
+		Mem:         vm.Original,
+		Original:    vm.Original,
+		Input:       input,
+		Stack:       vm.Stack[:0],
+		CycleLimit:  vm.CycleLimit,
+		ProgramSize: vm.ProgramSize,
+		decoded:     vm.decoded, // RunFast clears it
+	}
+	fresh.KeepSettings(vm)
+	for i := 0; i < fresh.ProgramSize; i++ {
+		fresh.initialized[i] = true
+	}
+	*vm = fresh
+}
+
 func (vm *VM) Run() error {
 	for vm.State == Running {
 		if err := vm.Step(); err != nil {
 			return err
 		}
-		if vm.BreakpointSet(vm.PC) {
+		if vm.BreakpointSet(vm.PC) || vm.watchHit != nil || vm.waitingForInput {
+			break
+		}
+		if vm.BreakOnWarning && vm.warning != nil {
+			break
+		}
+		if vm.BreakOnSelfModify && (vm.selfMod != nil && !vm.selfMod.Executing || vm.ModifiedCodeNext()) {
 			break
 		}
 	}
@@ -100,14 +221,44 @@ func (vm *VM) BreakpointSet(addr uint16) bool {
 	return vm.BreakPoints[(addr%MemSize)>>1]
 }
 
+func (vm *VM) ToggleWatchpoint(addr uint16) {
+	vm.WatchPoints[addr%MemSize] = !vm.WatchPoints[addr%MemSize]
+}
+
+// WatchTriggered returns the watched address changed by the last Step.
+func (vm *VM) WatchTriggered() (uint16, bool) {
+	if vm.watchHit == nil {
+		return 0, false
+	}
+	return *vm.watchHit, true
+}
+
+// WaitingForInput reports whether the last Step stopped at LES because
+// interactive input ran out, the instruction is retried by the next Step.
+func (vm *VM) WaitingForInput() bool {
+	return vm.waitingForInput
+}
+
 func (vm *VM) Step() error {
+	vm.watchHit = nil
+	vm.waitingForInput = false
+	vm.selfMod = nil
+	vm.warning = nil
+
 	if vm.CycleLimit > 0 && vm.CycleCount >= vm.CycleLimit {
-		return vm.setError(ErrCycleLimitExceeded)
+		return vm.fail(KindCycleLimit, nil)
 	}
 
+	vm.trackExecution()
+	vm.checkExecution()
+
 	var nextPC *uint16
 
 	i := ParseInstruction(vm.GetWord(vm.PC))
+	if vm.TrackTaint {
+		vm.propagateTaint(i)
+	}
+
 	switch i.Class {
 	case OpClassHalt:
 		vm.State = Stopped
@@ -125,12 +276,21 @@ func (vm *VM) Step() error {
 
 	case OpClassLoadStore:
 		if i.Op == 0 {
-			vm.SetReg(i.Arg1, vm.GetByte(vm.GetLoadStoreOffset()))
+			offset := vm.GetLoadStoreOffset() % MemSize
+			if !vm.initialized[offset] {
+				vm.warn(WarnUninitializedRead, offset)
+			}
+			vm.SetReg(i.Arg1, vm.GetByte(offset))
 		} else if i.Op == 1 {
-			vm.SetByte(vm.GetLoadStoreOffset(), vm.GetReg(i.Arg1))
+			offset := vm.GetLoadStoreOffset() % MemSize
+			if vm.WatchPoints[offset] && vm.GetByte(offset) != vm.GetReg(i.Arg1) {
+				vm.watchHit = &offset
+			}
+			vm.trackWrite(offset, vm.GetReg(i.Arg1))
+			vm.SetByte(offset, vm.GetReg(i.Arg1))
 		} else {
-			return vm.setError(errors.Errorf("Unsupported load/store op %d (PC %04x)",
-				i.Op, vm.PC))
+			return vm.fail(KindBadInstruction,
+				errors.Errorf("Unsupported load/store op %d", i.Op))
 		}
 
 	case OpClassALU:
@@ -150,22 +310,30 @@ func (vm *VM) Step() error {
 		case 6:
 			vm.SetReg(i.Arg1, vm.GetReg(i.Arg1)-vm.GetReg(i.Arg2))
 		default:
-			return vm.setError(errors.Errorf("Unsupported ALU op %d (PC %04x)",
-				i.Op, vm.PC))
+			return vm.fail(KindBadInstruction,
+				errors.Errorf("Unsupported ALU op %d", i.Op))
 		}
 
 	case OpClassIO:
 		if i.Op == 0 {
+			if err := vm.fillInput(); err != nil {
+				return err
+			} else if vm.InteractiveInput && vm.InputIndex >= len(vm.Input) {
+				vm.waitingForInput = true
+				return nil
+			}
 			if value, err := vm.readInput(); err != nil {
 				return err
 			} else {
 				vm.SetReg(i.Arg1, value)
 			}
 		} else if i.Op == 1 {
-			vm.writeOutput(vm.GetReg(i.Arg1))
+			if err := vm.writeOutput(vm.GetReg(i.Arg1)); err != nil {
+				return err
+			}
 		} else {
-			return vm.setError(errors.Errorf("Unsupported IO op %d (PC %04x)",
-				i.Op, vm.PC))
+			return vm.fail(KindBadInstruction,
+				errors.Errorf("Unsupported IO op %d", i.Op))
 		}
 
 	case OpClassCmp:
@@ -183,8 +351,8 @@ func (vm *VM) Step() error {
 		case 5:
 			vm.Flag = vm.GetReg(i.Arg1) >= vm.GetReg(i.Arg2)
 		default:
-			return vm.setError(errors.Errorf("Unsupported Cmp op %d (PC %04x)",
-				i.Op, vm.PC))
+			return vm.fail(KindBadInstruction,
+				errors.Errorf("Unsupported Cmp op %d", i.Op))
 		}
 
 	case OpClassJmp:
@@ -196,8 +364,10 @@ func (vm *VM) Step() error {
 		}
 
 	case OpClassCall:
+		if err := vm.push((vm.PC + 2) % MemSize); err != nil {
+			return err
+		}
 		nextPC = &i.Addr
-		vm.push(vm.PC + 2)
 
 	case OpClassRet:
 		if retAddr, err := vm.pop(); err != nil {
@@ -209,13 +379,16 @@ func (vm *VM) Step() error {
 	case OpClassNop:
 
 	default:
-		return vm.setError(errors.Errorf("Unsupported instruction class"+
-			" %d (PC %04x)", i.Class, vm.PC))
+		return vm.fail(KindBadInstruction,
+			errors.Errorf("Unsupported instruction class %d", i.Class))
 	}
 
 	if nextPC != nil {
 		vm.PC = *nextPC
 	} else {
+		if vm.PC%MemSize+2 >= MemSize {
+			vm.warn(WarnPCWraparound, 0)
+		}
 		vm.PC = (vm.PC + 2) % MemSize
 	}
 
@@ -243,8 +416,10 @@ func (vm *VM) GetByte(offset uint16) byte {
 	return vm.Mem[offset%MemSize]
 }
 
+// SetByte writes memory, which counts as initialized afterwards.
 func (vm *VM) SetByte(offset uint16, value byte) {
 	vm.Mem[offset%MemSize] = value
+	vm.initialized[offset%MemSize] = true
 }
 
 func (vm *VM) SetReg(reg int, value byte) {
@@ -259,7 +434,7 @@ func (vm *VM) GetReg(reg int) byte {
 
 func (vm *VM) readInput() (value byte, err error) {
 	if vm.InputIndex >= len(vm.Input) {
-		return 0, vm.setError(ErrNoMoreInput)
+		return 0, vm.fail(KindNoMoreInput, nil)
 	}
 
 	value = vm.Input[vm.InputIndex]
@@ -268,18 +443,47 @@ func (vm *VM) readInput() (value byte, err error) {
 	return
 }
 
-func (vm *VM) writeOutput(value byte) {
+// fillInput reads the next byte from InputSource when Input has been
+// consumed.
+func (vm *VM) fillInput() error {
+	if vm.InputSource == nil || vm.InputIndex < len(vm.Input) {
+		return nil
+	}
+
+	value, err := vm.InputSource.ReadByte()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return vm.fail(KindDevice, errors.Wrap(err, "Input"))
+	}
+
+	vm.Input = append(vm.Input, value)
+	return nil
+}
+
+func (vm *VM) writeOutput(value byte) error {
 	vm.Output = append(vm.Output, value)
+
+	if vm.OutputSink != nil {
+		if err := vm.OutputSink.WriteByte(value); err != nil {
+			return vm.fail(KindDevice, errors.Wrap(err, "Output"))
+		}
+	}
+	return nil
 }
 
-func (vm *VM) push(value uint16) {
-	// TODO: consider introducing a limit
+func (vm *VM) push(value uint16) error {
+	if vm.StackLimit > 0 && len(vm.Stack) >= vm.StackLimit {
+		return vm.fail(KindStackOverflow, nil)
+	}
+
 	vm.Stack = append(vm.Stack, value)
+	return nil
 }
 
 func (vm *VM) pop() (value uint16, err error) {
 	if len(vm.Stack) == 0 {
-		return 0, vm.setError(ErrEmptyStack)
+		return 0, vm.fail(KindEmptyStack, nil)
 	}
 
 	value = vm.Stack[len(vm.Stack)-1]
@@ -287,9 +491,3 @@ func (vm *VM) pop() (value uint16, err error) {
 
 	return
 }
-
-func (vm *VM) setError(err error) error {
-	vm.State = Error
-	vm.LastError = err
-	return err
-}
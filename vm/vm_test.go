@@ -0,0 +1,152 @@
+package vm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/upryst/slede8dbg/assembler"
+	"github.com/upryst/slede8dbg/vm"
+)
+
+func TestStackLimit(t *testing.T) {
+	code, err := assembler.Assemble("recurse:\n    TUR recurse\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int{1, 16} {
+		m, _ := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, 1000)
+		m.StackLimit = limit
+
+		if err := m.Run(); !errors.Is(err, vm.ErrStackOverflow) {
+			t.Fatalf("Limit %d: expected ErrStackOverflow, got %v", limit, err)
+		}
+		// The failing TUR isn't executed
+		if len(m.Stack) != limit || m.CycleCount != limit || m.PC != 0 || m.State != vm.Error {
+			t.Errorf("Limit %d: unexpected depth %d after %d cycles at 0x%03x",
+				limit, len(m.Stack), m.CycleCount, m.PC)
+		}
+	}
+
+	m, _ := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, 1000)
+	m.StackLimit = 0
+	if err := m.Run(); !errors.Is(err, vm.ErrCycleLimitExceeded) || len(m.Stack) != 1000 {
+		t.Errorf("Expected an unlimited stack with limit 0, got %v at depth %d", err, len(m.Stack))
+	}
+}
+
+func TestNPSTStackLimit(t *testing.T) {
+	code, err := assembler.Assemble("recurse:\n    TUR recurse\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, 1000)
+	if m.StackLimit != vm.NPSTStackLimit {
+		t.Fatalf("Expected the NPST limit by default, got %d", m.StackLimit)
+	}
+
+	// The last TUR fitting on the stack
+	for i := 0; i < vm.NPSTStackLimit; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("TUR %d failed: %v", i+1, err)
+		}
+	}
+	if len(m.Stack) != vm.NPSTStackLimit || m.State != vm.Running {
+		t.Fatalf("Expected depth %d, got %d", vm.NPSTStackLimit, len(m.Stack))
+	}
+
+	// and the first one not
+	if err := m.Step(); !errors.Is(err, vm.ErrStackOverflow) {
+		t.Fatalf("Expected ErrStackOverflow, got %v", err)
+	}
+	if len(m.Stack) != vm.NPSTStackLimit || m.CycleCount != vm.NPSTStackLimit || m.State != vm.Error {
+		t.Errorf("Unexpected depth %d after %d cycles", len(m.Stack), m.CycleCount)
+	}
+}
+
+func TestVMError(t *testing.T) {
+	code, err := assembler.Assemble("SETT r0, 1\nRETUR\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ := vm.NewVM(append([]byte(vm.SledeHeader), code...), nil, 1000)
+	err = m.Run()
+
+	var vmErr *vm.VMError
+	if !errors.As(err, &vmErr) || !errors.Is(err, vm.ErrEmptyStack) || errors.Is(err, vm.ErrNoMoreInput) {
+		t.Fatalf("Expected a VMError for ErrEmptyStack, got %v", err)
+	}
+	if vmErr.Kind != vm.KindEmptyStack || vmErr.PC != 2 || vmErr.Cycle != 1 || vmErr.Word != 0x000b {
+		t.Errorf("Unexpected error %+v", vmErr)
+	}
+	if expected := "Stack is empty at 0x002 (cycle 1, word 000b: RETUR)"; err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+
+	var buf bytes.Buffer
+	if err := m.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := vm.LoadState(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(loaded.LastError, vm.ErrEmptyStack) || loaded.LastError.Error() != m.LastError.Error() {
+		t.Errorf("Expected %v after loading, got %v", m.LastError, loaded.LastError)
+	}
+}
+
+func TestCloneReset(t *testing.T) {
+	code, err := assembler.Assemble(`
+    FINN buffer
+loop:
+    LES r2
+    LAGR r2
+    SKRIV r2
+    TUR sub
+    HOPP loop
+sub:
+    RETUR
+buffer:
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program := append([]byte(vm.SledeHeader), code...)
+
+	m, _ := vm.NewVM(program, []byte("ab"), 1000)
+	m.StackLimit = 4
+	m.ToggleBreakpoint(10)
+	m.Run()
+
+	// The clone goes its own way
+	clone := m.Clone()
+	clone.Input = append(clone.Input, 'c')
+	clone.ToggleBreakpoint(10)
+	clone.Run()
+	m.Run()
+	if string(m.Output) != "ab" || string(clone.Output) != "abc" || clone.Mem[0xe] != 'c' || m.Mem[0xe] != 'b' {
+		t.Errorf("Clone isn't independent: %q, %q", m.Output, clone.Output)
+	}
+
+	// Resetting is loading again
+	m.Reset([]byte("xy"))
+	fresh, _ := vm.NewVM(program, []byte("xy"), 1000)
+	fresh.KeepSettings(m)
+	if diff := vm.DiffStates(m, fresh); len(diff) > 0 {
+		t.Errorf("Reset differs from NewVM: %v", diff)
+	}
+	if m.StackLimit != 4 || !m.BreakpointSet(10) {
+		t.Error("Reset should keep the settings")
+	}
+
+	m.RunFast()
+	fresh.RunFast()
+	if diff := vm.DiffStates(m, fresh); len(diff) > 0 || m.Initialized(0xe) != fresh.Initialized(0xe) {
+		t.Errorf("Reset runs differently: %v", diff)
+	}
+}